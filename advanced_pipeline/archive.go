@@ -0,0 +1,261 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// openArchive opens the zip or tar archive at name as a filesystem so
+// its contents can be digested without extracting it.  Gzipped tars
+// (.tar.gz, .tgz) cannot be read at an offset, they are decompressed
+// a buffer at a time into a temporary file, removed again on Close,
+// so memory stays flat however large the archive.  Plain tars and
+// zips are read from disk on demand.
+func openArchive(name string) (fs.FS, io.Closer, error) {
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		z, err := zip.OpenReader(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		return z, z, nil
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		tmp, size, err := gunzipToTemp(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		t, err := newTarFS(tmp, size)
+		if err != nil {
+			tmp.Close()
+			return nil, nil, err
+		}
+		return t, tmp, nil
+	case strings.HasSuffix(name, ".tar"):
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		t, err := newTarFS(f, info.Size())
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return t, f, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported archive %q, expected .zip, .tar, .tar.gz or .tgz", name)
+}
+
+// gunzipToTemp decompresses the gzip file at name into a temporary
+// file, returning it along with its size.
+func gunzipToTemp(name string) (*tempFile, int64, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer gz.Close()
+
+	tmp, err := os.CreateTemp("", "md5all-*.tar")
+	if err != nil {
+		return nil, 0, err
+	}
+	t := &tempFile{tmp}
+	size, err := io.Copy(tmp, gz)
+	if err != nil {
+		t.Close()
+		return nil, 0, fmt.Errorf("decompressing %s: %w", name, err)
+	}
+	return t, size, nil
+}
+
+// tempFile is a temporary file removed once it is closed.
+type tempFile struct {
+	*os.File
+}
+
+// Close implements io.Closer, removing the file.
+func (t *tempFile) Close() error {
+	return errors.Join(t.File.Close(), os.Remove(t.Name()))
+}
+
+// tarFS is a read-only fs.FS over a tar archive.  The archive is
+// indexed once up front, after which files are read straight from
+// their offset in r, so any number of them can be open at once.
+// Directories missing from the archive are implied by the paths of
+// the files within them.  Symlinks appear as such and cannot be
+// opened, other special files are left out.
+type tarFS struct {
+	r     io.ReaderAt
+	nodes map[string]*tarNode
+}
+
+// tarNode is a file or directory within a tarFS.
+type tarNode struct {
+	info     fs.FileInfo
+	offset   int64
+	children []fs.DirEntry // directories only, sorted by name
+}
+
+// newTarFS indexes the tar archive of the given size in r.
+func newTarFS(r io.ReaderAt, size int64) (*tarFS, error) {
+	t := &tarFS{r: r, nodes: make(map[string]*tarNode)}
+	t.nodes["."] = &tarNode{info: impliedDir(".")}
+
+	// the position of cr after Next is where the entry's data starts.
+	cr := &offsetReader{r: io.NewSectionReader(r, 0, size)}
+	tr := tar.NewReader(cr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		if !fs.ValidPath(name) || name == "." {
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeDir, tar.TypeSymlink:
+		default:
+			continue
+		}
+		t.parent(name)
+		n := &tarNode{info: hdr.FileInfo(), offset: cr.n}
+		if old, ok := t.nodes[name]; ok && old.info.IsDir() && n.info.IsDir() {
+			n.children = old.children
+		}
+		t.nodes[name] = n
+	}
+
+	for name, n := range t.nodes {
+		if name == "." {
+			continue
+		}
+		p := t.nodes[path.Dir(name)]
+		p.children = append(p.children, fs.FileInfoToDirEntry(n.info))
+	}
+	for _, n := range t.nodes {
+		slices.SortFunc(n.children, func(a, b fs.DirEntry) int {
+			return strings.Compare(a.Name(), b.Name())
+		})
+	}
+	return t, nil
+}
+
+// parent makes sure every directory above name exists.
+func (t *tarFS) parent(name string) {
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if _, ok := t.nodes[dir]; ok {
+			return
+		}
+		t.nodes[dir] = &tarNode{info: impliedDir(dir)}
+	}
+}
+
+// Open implements fs.FS.
+func (t *tarFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	n, ok := t.nodes[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	switch {
+	case n.info.IsDir():
+		return &tarDir{info: n.info, entries: n.children}, nil
+	case n.info.Mode().IsRegular():
+		return &tarFile{info: n.info, SectionReader: io.NewSectionReader(t.r, n.offset, n.info.Size())}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("not a regular file or directory")}
+}
+
+// tarFile is an open regular file within a tarFS.
+type tarFile struct {
+	info fs.FileInfo
+	*io.SectionReader
+}
+
+// Stat implements fs.File.
+func (f *tarFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+// Close implements fs.File.
+func (f *tarFile) Close() error { return nil }
+
+// tarDir is an open directory within a tarFS.
+type tarDir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	read    int
+}
+
+// Stat implements fs.File.
+func (d *tarDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+
+// Close implements fs.File.
+func (d *tarDir) Close() error { return nil }
+
+// Read implements fs.File, directories cannot be read.
+func (d *tarDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: errors.New("is a directory")}
+}
+
+// ReadDir implements fs.ReadDirFile.
+func (d *tarDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.read:]
+	if n <= 0 {
+		d.read = len(d.entries)
+		return slices.Clone(rest), nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	rest = rest[:min(n, len(rest))]
+	d.read += len(rest)
+	return slices.Clone(rest), nil
+}
+
+// impliedDir describes a directory a tar archive has files in but no
+// entry for.
+type impliedDir string
+
+func (d impliedDir) Name() string       { return path.Base(string(d)) }
+func (d impliedDir) Size() int64        { return 0 }
+func (d impliedDir) Mode() fs.FileMode  { return fs.ModeDir | 0o555 }
+func (d impliedDir) ModTime() time.Time { return time.Time{} }
+func (d impliedDir) IsDir() bool        { return true }
+func (d impliedDir) Sys() any           { return nil }
+
+// offsetReader tracks how far into r it has read.
+type offsetReader struct {
+	r io.Reader
+	n int64
+}
+
+// Read implements io.Reader.
+func (o *offsetReader) Read(p []byte) (int, error) {
+	n, err := o.r.Read(p)
+	o.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// archived is the tree every archive test packs up.
+var archived = fstest.MapFS{
+	"top.txt":            {Data: []byte("top"), Mode: 0o644},
+	"empty":              {Data: nil, Mode: 0o644},
+	"dir/a.txt":          {Data: []byte("a"), Mode: 0o644},
+	"dir/sub/b.txt":      {Data: bytes.Repeat([]byte("b"), 100_000), Mode: 0o644},
+	"implied/only/c.txt": {Data: []byte("c"), Mode: 0o644},
+}
+
+// writeTar packs fsys into a tar, without directory entries, so that
+// tarFS has to imply them.
+func writeTar(t *testing.T, w io.Writer, fsys fstest.MapFS) {
+	t.Helper()
+	tw := tar.NewWriter(w)
+	for name, f := range fsys {
+		hdr := &tar.Header{Name: name, Mode: int64(f.Mode), Size: int64(len(f.Data)), ModTime: time.Unix(0, 0), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(f.Data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeArchive packs fsys into an archive named name in a temporary
+// directory, in the format its extension asks for.
+func writeArchive(t *testing.T, name string, fsys fstest.MapFS) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	switch filepath.Ext(name) {
+	case ".zip":
+		zw := zip.NewWriter(f)
+		for name, file := range fsys {
+			w, err := zw.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write(file.Data)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	case ".gz", ".tgz":
+		gz := gzip.NewWriter(f)
+		writeTar(t, gz, fsys)
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+	default:
+		writeTar(t, f, fsys)
+	}
+	return path
+}
+
+func TestTarFS(t *testing.T) {
+	var buf bytes.Buffer
+	writeTar(t, &buf, archived)
+	tfs, err := newTarFS(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(tfs, "top.txt", "empty", "dir/a.txt", "dir/sub/b.txt", "implied/only/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenArchive(t *testing.T) {
+	want, err := md5All(".", withFS(archived))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want) != len(archived) {
+		t.Fatalf("digested %d files of the map, want %d", len(want), len(archived))
+	}
+
+	for _, name := range []string{"tree.zip", "tree.tar", "tree.tar.gz", "tree.tgz"} {
+		t.Run(name, func(t *testing.T) {
+			fsys, closer, err := openArchive(writeArchive(t, name, archived))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := md5All(".", withFS(fsys), withWorkers(2))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.EqualFunc(got, want, bytes.Equal) {
+				t.Errorf("got %x, want %x", got, want)
+			}
+
+			tmp, spilled := closer.(*tempFile)
+			if err := closer.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if spilled {
+				if _, err := os.Stat(tmp.Name()); !os.IsNotExist(err) {
+					t.Errorf("temporary file %s left behind: %v", tmp.Name(), err)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenArchiveUnsupported(t *testing.T) {
+	if _, _, err := openArchive("tree.rar"); err == nil {
+		t.Fatal("opened a .rar")
+	}
+}
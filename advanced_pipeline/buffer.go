@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+// errDigestCancelled is returned by a digest abandoned because the
+// pipeline was cancelled while it waited for a read buffer.
+var errDigestCancelled = errors.New("digest cancelled")
+
+// bufferPool hands out fixed size read buffers for streaming files
+// through a hash.  Buffers are recycled through a sync.Pool and the
+// number checked out at any one time is capped, so the memory held
+// by in-flight reads never exceeds the limit the pool was built with,
+// regardless of how many digesters are running or how large the
+// files they read are.
+type bufferPool struct {
+	pool sync.Pool
+	sem  chan struct{}
+}
+
+// newBufferPool returns a pool of size byte buffers that allows at
+// most limit bytes to be checked out at once.  At least one buffer
+// is always available.
+func newBufferPool(size, limit int) *bufferPool {
+	n := max(limit/size, 1)
+	return &bufferPool{
+		pool: sync.Pool{New: func() any {
+			b := make([]byte, size)
+			return &b
+		}},
+		sem: make(chan struct{}, n),
+	}
+}
+
+// get blocks until a buffer is available or done is closed.
+// Every buffer returned must be handed back with put.
+func (b *bufferPool) get(done <-chan struct{}) (*[]byte, error) {
+	select {
+	case b.sem <- struct{}{}:
+		return b.pool.Get().(*[]byte), nil
+	case <-done:
+		return nil, errDigestCancelled
+	}
+}
+
+// put returns buf to the pool, unblocking a waiting get.
+func (b *bufferPool) put(buf *[]byte) {
+	b.pool.Put(buf)
+	<-b.sem
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCacheName is the digest cache file kept in the root of the
+// tree when no other location is given.
+const defaultCacheName = ".md5all.cache"
+
+// isCacheFile returns a function reporting whether a path walked from
+// root is the cache file at cache, or one of the temporary files it is
+// saved through, nil if the cache is not within root.  root and cache
+// are made absolute once, to find where the cache is within root, the
+// walked paths are then compared as they are.
+func isCacheFile(root, cache string) func(path string) bool {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil
+	}
+	absCache, err := filepath.Abs(cache)
+	if err != nil {
+		return nil
+	}
+	rel, err := filepath.Rel(absRoot, absCache)
+	if err != nil || !filepath.IsLocal(rel) {
+		return nil
+	}
+	cache = filepath.Join(root, rel)
+	dir, tmp := filepath.Dir(cache), filepath.Base(cache)+".tmp"
+	return func(path string) bool {
+		path = filepath.Clean(path)
+		return path == cache || filepath.Dir(path) == dir && strings.HasPrefix(filepath.Base(path), tmp)
+	}
+}
+
+// cacheEntry is what the cache knows about a single file.  A file is
+// considered unchanged while its size, modification time and inode all
+// match.
+type cacheEntry struct {
+	Size  int64  `json:"size"`
+	MTime int64  `json:"mtime"` // unix nanoseconds
+	Inode uint64 `json:"inode"`
+	Sum   []byte `json:"sum"`
+}
+
+// cacheFile is the on-disk layout of the cache.
+type cacheFile struct {
+	// Hash is the digest of no input, it identifies the algorithm the
+	// entries were built with without needing to know its name.
+	Hash    []byte                `json:"hash"`
+	Entries map[string]cacheEntry `json:"entries"`
+}
+
+// digestCache remembers digests between runs so files that have not
+// changed are not read again.  It is safe for concurrent use by the
+// digesters, a nil *digestCache is a valid, always missing, cache.
+//
+// The file is only ever replaced by renaming a fully written temporary
+// file over it, so a run that crashes part way leaves the previous
+// cache intact.
+type digestCache struct {
+	path    string
+	hash    []byte
+	started time.Time
+	prev    map[string]cacheEntry // read only once loaded
+
+	mu   sync.Mutex
+	next map[string]cacheEntry
+}
+
+// loadCache reads the cache at path.  A cache that is missing, corrupt
+// or was built with a different algorithm, identified by its digest of
+// no input, is discarded, the run then starts from empty and rebuilds
+// it.
+func loadCache(path string, empty []byte) *digestCache {
+	c := &digestCache{
+		path:    path,
+		hash:    empty,
+		started: time.Now(),
+		prev:    make(map[string]cacheEntry),
+		next:    make(map[string]cacheEntry),
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return c
+	}
+	var f cacheFile
+	if err := json.Unmarshal(data, &f); err != nil || string(f.Hash) != string(c.hash) {
+		return c
+	}
+	if f.Entries != nil {
+		c.prev = f.Entries
+	}
+	return c
+}
+
+// newCacheEntry describes the file info belongs to.
+func newCacheEntry(info os.FileInfo, sum []byte) cacheEntry {
+	return cacheEntry{
+		Size:  info.Size(),
+		MTime: info.ModTime().UnixNano(),
+		Inode: inode(info),
+		Sum:   sum,
+	}
+}
+
+// lookup returns the cached digest for path if the file is unchanged.
+func (c *digestCache) lookup(path string, info os.FileInfo) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	cached, ok := c.prev[path]
+	if !ok {
+		return nil, false
+	}
+	current := newCacheEntry(info, cached.Sum)
+	if current.Size != cached.Size || current.MTime != cached.MTime || current.Inode != cached.Inode {
+		return nil, false
+	}
+	c.mu.Lock()
+	c.next[path] = cached
+	c.mu.Unlock()
+	return cached.Sum, true
+}
+
+// store records the digest of path.  Files modified since the run
+// started are not recorded, a later write within the same mtime tick
+// would otherwise go unnoticed.
+func (c *digestCache) store(path string, info os.FileInfo, sum []byte) {
+	if c == nil || !info.ModTime().Before(c.started) {
+		return
+	}
+	c.mu.Lock()
+	c.next[path] = newCacheEntry(info, sum)
+	c.mu.Unlock()
+}
+
+// save atomically replaces the cache file with what this run learned.
+// Only a complete walk prunes files that no longer exist, an abandoned
+// one keeps the entries it did not get round to checking.
+func (c *digestCache) save(complete bool) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	entries := make(map[string]cacheEntry, len(c.next))
+	for path, e := range c.next {
+		entries[path] = e
+	}
+	c.mu.Unlock()
+	if !complete {
+		for path, e := range c.prev {
+			if _, ok := entries[path]; !ok {
+				entries[path] = e
+			}
+		}
+	}
+
+	data, err := json.Marshal(cacheFile{Hash: c.hash, Entries: entries})
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
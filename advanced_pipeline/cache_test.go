@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsCacheFile(t *testing.T) {
+	root := "root"
+	cache := filepath.Join(root, defaultCacheName)
+	abs, err := filepath.Abs(cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{cache, true},
+		{filepath.Join(root, ".", defaultCacheName), true},
+		{cache + ".tmp123", true},
+		{cache + ".bak", false},
+		{filepath.Join(root, defaultCacheName+"-notes.txt"), false},
+		{filepath.Join(root, "sub", defaultCacheName), false},
+		{filepath.Join(root, "file"), false},
+	}
+	for _, c := range []string{cache, abs} {
+		isCache := isCacheFile(root, c)
+		for _, tt := range tests {
+			if got := isCache(tt.path); got != tt.want {
+				t.Errorf("isCacheFile(%q, %q)(%q) = %v, want %v", root, c, tt.path, got, tt.want)
+			}
+		}
+	}
+
+	// a cache kept elsewhere is never walked into.
+	if isCacheFile(root, filepath.Join("elsewhere", defaultCacheName)) != nil {
+		t.Error("got a filter for a cache outside the root")
+	}
+}
+
+func TestCacheFileDigestedOnlyWithoutCache(t *testing.T) {
+	root := writeTree(t, 4, 16)
+	if _, err := md5All(root, withCache("")); err != nil {
+		t.Fatal(err)
+	}
+	cache := filepath.Join(root, defaultCacheName)
+	if _, err := os.Stat(cache); err != nil {
+		t.Fatalf("no cache written: %v", err)
+	}
+	lookalike := filepath.Join(root, defaultCacheName+"-notes.txt")
+	if err := os.WriteFile(lookalike, []byte("kept"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	modes := []struct {
+		name   string
+		opts   []option
+		cached bool
+	}{
+		{"plain", nil, false},
+		{"bounded", []option{withWorkers(2)}, false},
+		{"cached", []option{withCache("")}, true},
+		{"cached and bounded", []option{withCache(""), withWorkers(2)}, true},
+	}
+	for _, m := range modes {
+		t.Run(m.name, func(t *testing.T) {
+			sums, err := md5All(root, m.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// without a cache a file of that name is the user's own.
+			if _, ok := sums[cache]; ok == m.cached {
+				t.Errorf("the cache file digested is %v, want %v", ok, !m.cached)
+			}
+			if _, ok := sums[lookalike]; !ok {
+				t.Errorf("%s was skipped along with the cache", lookalike)
+			}
+		})
+	}
+
+	t.Run("tree", func(t *testing.T) {
+		before, err := merkleAll(root, withCache(""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := before.sums[defaultCacheName]; ok {
+			t.Errorf("the cache file is in the tree")
+		}
+		if _, err := md5All(root, withCache("")); err != nil {
+			t.Fatal(err)
+		}
+		after, err := merkleAll(root, withCache(""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed := diffTrees(before, after); len(changed) > 0 {
+			t.Errorf("saving the cache changed the tree: %v", changed)
+		}
+
+		plain, err := merkleAll(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := plain.sums[defaultCacheName]; !ok {
+			t.Errorf("the cache file is left out of a tree built without it")
+		}
+	})
+}
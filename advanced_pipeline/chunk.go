@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Domain separation bytes for the chunked tree hash, so a leaf can
+// never be mistaken for a root or the other way around.
+const (
+	leafPrefix = 0x00
+	rootPrefix = 0x01
+)
+
+// chunkedRead digests the file at path as a two level hash tree, so
+// that a single large file can be hashed on several cores at once.
+//
+// The file is split into chunks of h.chunk bytes, the last possibly
+// shorter, and each chunk is hashed on its own as a leaf:
+//
+//	leaf = H(0x00 || chunk)
+//
+// The root is then the hash of the chunk size, the file size and the
+// leaves in file order:
+//
+//	root = H(0x01 || uint64 chunk size || uint64 file size || leaf...)
+//
+// This is NOT the digest plain md5 (or whichever algorithm is in use)
+// gives for the file, and it changes with the chunk size, so chunked
+// digests can only be compared with others made with the same chunk
+// size.  It does not depend on how many goroutines did the work.
+//
+// Files that can be read at an offset have their chunks read through
+// an io.SectionReader each, by up to h.chunkWorkers goroutines.  Each
+// of them needs a read buffer per chunk, so the memory limit holds.
+// Other files, those in a compressed archive, are hashed a chunk at a
+// time by the calling goroutine, to the same digest.
+func (h *hasher) chunkedRead(done <-chan struct{}, path string, size int64) ([]byte, error) {
+	buf, err := h.buffers.get(done)
+	if err != nil {
+		return nil, err
+	}
+	defer h.buffers.put(buf)
+
+	f, err := openFile(h.fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	leaves := make([][]byte, (size+h.chunk-1)/h.chunk)
+	ra, ok := f.(io.ReaderAt)
+	if !ok {
+		for i := range leaves {
+			if leaves[i], err = h.leaf(*buf, io.LimitReader(f, h.chunk)); err != nil {
+				return nil, err
+			}
+		}
+		return h.root(size, leaves), nil
+	}
+
+	var (
+		stop     = make(chan struct{})
+		stopOnce sync.Once
+		firstErr error
+		finished atomic.Int64
+	)
+	fail := func(err error) {
+		stopOnce.Do(func() {
+			firstErr = err
+			close(stop)
+		})
+	}
+
+	// chunks hands out chunk indices until they run out or the digest
+	// is abandoned, at which point exhausted is closed.
+	chunks := make(chan int)
+	exhausted := make(chan struct{})
+	go func() {
+		defer close(exhausted)
+		defer close(chunks)
+		for i := range leaves {
+			select {
+			case chunks <- i:
+			case <-stop:
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+	hashChunk := func(buf []byte, i int) {
+		off := int64(i) * h.chunk
+		sum, err := h.leaf(buf, io.NewSectionReader(ra, off, min(h.chunk, size-off)))
+		if err != nil {
+			fail(err)
+			return
+		}
+		leaves[i] = sum
+		finished.Add(1)
+	}
+
+	// The calling goroutine already holds a buffer and can get through
+	// every chunk by itself, the helpers only join in when a buffer is
+	// free.  A helper takes a buffer before it takes a chunk and gives
+	// up waiting once the chunks run out, so it never sits on a chunk
+	// waiting for a buffer held by another file waiting on its helpers.
+	var wg sync.WaitGroup
+	for range min(h.chunkWorkers, len(leaves)) - 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				buf, err := h.buffers.get(exhausted)
+				if err != nil {
+					return
+				}
+				i, ok := <-chunks
+				if !ok {
+					h.buffers.put(buf)
+					return
+				}
+				hashChunk(*buf, i)
+				h.buffers.put(buf)
+			}
+		}()
+	}
+	for i := range chunks {
+		hashChunk(*buf, i)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if finished.Load() != int64(len(leaves)) {
+		return nil, errDigestCancelled
+	}
+	return h.root(size, leaves), nil
+}
+
+// leaf hashes a single chunk read from r through buf.
+func (h *hasher) leaf(buf []byte, r io.Reader) ([]byte, error) {
+	d := h.newHash()
+	d.Write([]byte{leafPrefix})
+	if _, err := io.CopyBuffer(d, &countingReader{r, &h.stats.bytesRead}, buf); err != nil {
+		return nil, err
+	}
+	return d.Sum(nil), nil
+}
+
+// root combines the leaves of a file of the given size into its digest.
+func (h *hasher) root(size int64, leaves [][]byte) []byte {
+	d := h.newHash()
+	d.Write([]byte{rootPrefix})
+	binary.Write(d, binary.BigEndian, uint64(h.chunk))
+	binary.Write(d, binary.BigEndian, uint64(size))
+	for _, l := range leaves {
+		d.Write(l)
+	}
+	return d.Sum(nil)
+}
+
+// emptySum is the digest of an empty file, it identifies both the
+// algorithm and, for chunked digests, the chunk size.
+func (h *hasher) emptySum() []byte {
+	if h.chunk > 0 {
+		return h.root(0, nil)
+	}
+	return h.newHash().Sum(nil)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+// treeSum is the chunked digest of data worked out the long way.
+func treeSum(data []byte, chunk int) []byte {
+	root := md5.New()
+	root.Write([]byte{rootPrefix})
+	binary.Write(root, binary.BigEndian, uint64(chunk))
+	binary.Write(root, binary.BigEndian, uint64(len(data)))
+	for off := 0; off < len(data); off += chunk {
+		leaf := md5.New()
+		leaf.Write([]byte{leafPrefix})
+		leaf.Write(data[off:min(off+chunk, len(data))])
+		root.Write(leaf.Sum(nil))
+	}
+	return root.Sum(nil)
+}
+
+// sequential hides io.ReaderAt from the regular files of an fs.FS, as
+// a compressed archive does, so they are hashed a chunk at a time.
+type sequential struct {
+	fs.FS
+}
+
+// Open implements fs.FS.
+func (s sequential) Open(name string) (fs.File, error) {
+	f, err := s.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err != nil || info.IsDir() {
+		return f, err
+	}
+	return struct{ fs.File }{f}, nil
+}
+
+func TestChunkedRead(t *testing.T) {
+	const chunk = 1 << 10
+	sizes := []struct {
+		name string
+		size int
+	}{
+		{"empty", 0},
+		{"one byte", 1},
+		{"short of a chunk", chunk - 1},
+		{"one chunk", chunk},
+		{"short last chunk", chunk + 1},
+		{"many chunks", 9*chunk + 7},
+	}
+	for _, sz := range sizes {
+		data := make([]byte, sz.size)
+		for i := range data {
+			data[i] = byte(i * 7)
+		}
+		want := treeSum(data, chunk)
+		fsys := fstest.MapFS{"f": {Data: data}}
+
+		for _, workers := range []int{1, 2, 3, 8, 32} {
+			t.Run(fmt.Sprintf("%s/workers=%d", sz.name, workers), func(t *testing.T) {
+				sums, err := md5All(".", withFS(fsys), withChunks(chunk, workers))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(sums["f"], want) {
+					t.Errorf("got %x, want %x", sums["f"], want)
+				}
+			})
+		}
+		t.Run(sz.name+"/sequential", func(t *testing.T) {
+			sums, err := md5All(".", withFS(sequential{fsys}), withChunks(chunk, 4))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(sums["f"], want) {
+				t.Errorf("got %x, want %x", sums["f"], want)
+			}
+		})
+	}
+}
+
+func TestChunkedReadOnDisk(t *testing.T) {
+	const chunk = 4 << 10
+	data := bytes.Repeat([]byte("0123456789"), 10_000)
+	path := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want := treeSum(data, chunk)
+	for _, workers := range []int{1, 4} {
+		sums, err := md5All(path, withChunks(chunk, workers), withReadBuffers(chunk, 2*chunk))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(sums[path], want) {
+			t.Errorf("workers=%d: got %x, want %x", workers, sums[path], want)
+		}
+	}
+}
+
+func TestEmptySum(t *testing.T) {
+	h := newConfig(withChunks(1<<10, 1)).hasher()
+	if got, want := h.emptySum(), treeSum(nil, 1<<10); !bytes.Equal(got, want) {
+		t.Errorf("chunked: got %x, want %x", got, want)
+	}
+	h = newConfig().hasher()
+	if got, want := h.emptySum(), md5.New().Sum(nil); !bytes.Equal(got, want) {
+		t.Errorf("plain: got %x, want %x", got, want)
+	}
+}
+
+// BenchmarkChunkedRead digests a single large file with more and more
+// goroutines hashing its chunks, the speedup levels off at the number
+// of cores.
+func BenchmarkChunkedRead(b *testing.B) {
+	const size, chunk = 64 << 20, 1 << 20
+	path := filepath.Join(b.TempDir(), "large")
+	if err := os.WriteFile(path, bytes.Repeat([]byte{0xa5}, size), 0o644); err != nil {
+		b.Fatal(err)
+	}
+	b.Run("whole", func(b *testing.B) {
+		b.SetBytes(size)
+		for range b.N {
+			if _, err := md5All(path); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	for _, workers := range []int{1, 2, 4, 8, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(size)
+			for range b.N {
+				if _, err := md5All(path, withChunks(chunk, workers)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
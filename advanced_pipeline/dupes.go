@@ -0,0 +1,158 @@
+package main
+
+import (
+	"cmp"
+	"encoding/hex"
+	"slices"
+	"strconv"
+
+	"github.com/symonk/concurrency/pipeline"
+)
+
+// partialSize is how much of each end of a file the partial hash reads.
+const partialSize = 4 << 10
+
+// dupeGroup is a set of files with identical content.
+type dupeGroup struct {
+	size  int64
+	paths []string
+}
+
+// wasted is the space freed by keeping only one file of the group.
+func (g dupeGroup) wasted() int64 {
+	return g.size * int64(len(g.paths)-1)
+}
+
+// collisionStage forwards only the values that share a key with at
+// least one other value.  The first value seen for a key is held back
+// until a second one arrives, then both are sent on, as is every value
+// with that key after them.  Values key reports as not ok bypass the
+// filter, this is how errors travel through to the sink.
+func collisionStage[T any](done <-chan struct{}, upstream <-chan T, key func(T) (string, bool)) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		send := func(v T) bool {
+			select {
+			case out <- v:
+				return true
+			case <-done:
+				return false
+			}
+		}
+
+		// held is nil for keys whose first value was already forwarded.
+		held := make(map[string]*T)
+		for v := range upstream {
+			k, ok := key(v)
+			first, seen := held[k]
+			switch {
+			case !ok:
+				if !send(v) {
+					return
+				}
+			case !seen:
+				held[k] = &v
+			case first != nil:
+				held[k] = nil
+				if !send(*first) || !send(v) {
+					return
+				}
+			default:
+				if !send(v) {
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// toEntry turns a digested result back into an entry so it can be
+// digested again by a different hasher.
+func toEntry(r result) entry {
+	return entry{path: r.path, info: r.info, err: r.err}
+}
+
+// sizeKey groups entries by file size.
+func sizeKey(en entry) (string, bool) {
+	if en.err != nil {
+		return "", false
+	}
+	return strconv.FormatInt(en.info.Size(), 10), true
+}
+
+// digestKey groups results by file size and digest.
+func digestKey(r result) (string, bool) {
+	if r.err != nil {
+		return "", false
+	}
+	return strconv.FormatInt(r.info.Size(), 10) + ":" + hex.EncodeToString(r.sum), true
+}
+
+// findDuplicates walks root and returns the groups of files with the
+// same content, the most wasteful first.
+//
+// It is the filtering pipeline the md5All doc comment describes, each
+// stage sees less of the tree than the one before it:
+//
+//	walk -> drop empty files -> size collisions -> partial hash
+//	     -> partial hash collisions -> full hash -> group
+//
+// Only files sharing a size are read at all, and only those whose
+// first and last partialSize bytes also match are read in full.
+// Errors are handled according to the error policy, as in md5All.
+func findDuplicates(root string, opts ...option) ([]dupeGroup, error) {
+	cfg := newConfig(opts...)
+	done := make(chan struct{})
+	defer close(done)
+
+	full := cfg.hasher()
+	partial := *full
+	partial.partial = partialSize
+
+	entries, errs := cfg.walk(done, root)
+	entries = pipeline.Filter(done, entries, func(en entry) bool {
+		return en.err != nil || en.info.Size() > 0
+	})
+	entries = collisionStage(done, entries, sizeKey)
+	heads := collisionStage(done, cfg.digestStage(done, entries, &partial), digestKey)
+	sums := cfg.digestStage(done, pipeline.Map(done, heads, toEntry), full)
+
+	groups := make(map[string]*dupeGroup)
+	var failed []result
+	for r := range sums {
+		if r.err != nil {
+			if cfg.policy == failFast {
+				return nil, r.err
+			}
+			failed = append(failed, r)
+			continue
+		}
+		k, _ := digestKey(r)
+		g, ok := groups[k]
+		if !ok {
+			g = &dupeGroup{size: r.info.Size()}
+			groups[k] = g
+		}
+		g.paths = append(g.paths, r.path)
+	}
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+
+	var dupes []dupeGroup
+	for _, g := range groups {
+		if len(g.paths) > 1 {
+			slices.Sort(g.paths)
+			dupes = append(dupes, *g)
+		}
+	}
+	slices.SortFunc(dupes, func(a, b dupeGroup) int {
+		if c := cmp.Compare(b.wasted(), a.wasted()); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.paths[0], b.paths[0])
+	})
+	return dupes, joinFailures(failed)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// firstLetter keys strings on their first letter, those starting with
+// ! bypass the collision filter.
+func firstLetter(s string) (string, bool) {
+	return s[:1], s[0] != '!'
+}
+
+func TestCollisionStage(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []string
+		want []string
+	}{
+		{"none", nil, nil},
+		{"no collisions", []string{"a1", "b1", "c1"}, nil},
+		{"held until the second", []string{"a1", "b1", "a2", "c1", "a3", "b2"}, []string{"a1", "a2", "a3", "b1", "b2"}},
+		{"bypass", []string{"!x", "a1", "!y", "a2"}, []string{"!x", "!y", "a1", "a2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			done := make(chan struct{})
+			defer close(done)
+			upstream := make(chan string)
+			go func() {
+				defer close(upstream)
+				for _, s := range tt.in {
+					upstream <- s
+				}
+			}()
+			var got []string
+			for s := range collisionStage(done, upstream, firstLetter) {
+				got = append(got, s)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("cancelled", func(t *testing.T) {
+		done := make(chan struct{})
+		upstream := make(chan string, 2)
+		upstream <- "a1"
+		upstream <- "a2"
+		out := collisionStage(done, upstream, firstLetter)
+		// blocked sending a1 with nobody reading.
+		close(done)
+		select {
+		case <-out:
+		case <-time.After(time.Second):
+			t.Fatal("still blocked after done was closed")
+		}
+	})
+}
+
+func TestFindDuplicates(t *testing.T) {
+	big := strings.Repeat("x", 5*partialSize)
+	// the same ends as big, only the middle differs.
+	middle := big[:len(big)/2] + "y" + big[len(big)/2+1:]
+	root := writeFiles(t, map[string]string{
+		"a.txt":       "same",
+		"sub/b.txt":   "same",
+		"c.txt":       "diff",
+		"big1":        big,
+		"sub/big2":    big,
+		"sub/big3":    big,
+		"middle":      middle,
+		"empty1":      "",
+		"empty2":      "",
+		"unique.data": "no other file this size",
+	})
+	groups, err := findDuplicates(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := func(name string) string {
+		return filepath.Join(root, filepath.FromSlash(name))
+	}
+	want := []dupeGroup{
+		{int64(len(big)), []string{path("big1"), path("sub/big2"), path("sub/big3")}},
+		{4, []string{path("a.txt"), path("sub/b.txt")}},
+	}
+	if !slices.EqualFunc(groups, want, func(a, b dupeGroup) bool {
+		return a.size == b.size && slices.Equal(a.paths, b.paths)
+	}) {
+		t.Errorf("got %v, want %v", groups, want)
+	}
+	if w := groups[0].wasted(); w != 2*int64(len(big)) {
+		t.Errorf("the first group wastes %d, want %d", w, 2*len(big))
+	}
+}
+
+func TestFindDuplicatesErrorPolicy(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"a.txt":   "same",
+		"b.txt":   "same",
+		"bad.txt": "bad!",
+	})
+
+	groups, err := findDuplicates(root, withHash(newBadHash))
+	if !errors.Is(err, errBadContent) || groups != nil {
+		t.Errorf("fail fast: got %v, %v, want no groups and the error", groups, err)
+	}
+
+	groups, err = findDuplicates(root, withHash(newBadHash), withErrorPolicy(collectAll))
+	if !errors.Is(err, errBadContent) {
+		t.Errorf("collect all: got %v, want the error for bad.txt", err)
+	}
+	want := []string{filepath.Join(root, "a.txt"), filepath.Join(root, "b.txt")}
+	if len(groups) != 1 || !slices.Equal(groups[0].paths, want) {
+		t.Errorf("collect all: got %v, want %v", groups, want)
+	}
+}
+
+func TestRunDupesKeepGoing(t *testing.T) {
+	// no duplicates among the files that could be digested.
+	root := writeFiles(t, map[string]string{"a.txt": "one", "bad.txt": "bad"})
+	if code := runDupes(root, withHash(newBadHash), withErrorPolicy(collectAll)); code != 1 {
+		t.Errorf("exited %d, want 1", code)
+	}
+	if code := runDupes(root, withErrorPolicy(collectAll)); code != 0 {
+		t.Errorf("exited %d without errors, want 0", code)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// symlinkPolicy decides what the walk does with symlinks.
+type symlinkPolicy int
+
+const (
+	// symlinkSkip ignores symlinks entirely.
+	symlinkSkip symlinkPolicy = iota
+	// symlinkFollow digests the files links point to and walks the
+	// directories they point to, skipping links that lead back into a
+	// directory already being walked.
+	symlinkFollow
+	// symlinkTarget digests the path a link points to, not its content.
+	symlinkTarget
+)
+
+// parseSymlinkPolicy validates a symlink policy given on the command line.
+func parseSymlinkPolicy(name string) (symlinkPolicy, error) {
+	switch name {
+	case "skip":
+		return symlinkSkip, nil
+	case "follow":
+		return symlinkFollow, nil
+	case "target":
+		return symlinkTarget, nil
+	}
+	return 0, fmt.Errorf("unknown symlink policy %q, expected skip, follow or target", name)
+}
+
+// walkFilter decides which of the walked files are digested.  It only
+// looks at paths, relative to the root and slash separated, so it can
+// sit in its own stage between the walk and the digesters.
+//
+// Patterns use path.Match syntax against whole path segments, with **
+// matching any number of segments.  A pattern without a slash matches
+// a single name at any depth, one with a slash matches from the root.
+type walkFilter struct {
+	include    []string
+	exclude    []string
+	maxDepth   int
+	skipHidden bool
+	gitignore  bool
+
+	root    string
+	ignores *gitignore
+}
+
+// empty reports whether the filter lets everything through.
+func (f walkFilter) empty() bool {
+	return len(f.include) == 0 && len(f.exclude) == 0 && f.maxDepth <= 0 && !f.skipHidden && !f.gitignore
+}
+
+// forRoot returns the filter ready to judge paths walked from root,
+// within fsys if it is not nil.
+func (f walkFilter) forRoot(fsys fs.FS, root string) *walkFilter {
+	f.root = root
+	if f.gitignore {
+		f.ignores = &gitignore{fsys: fsys, root: root, rules: make(map[string][]ignoreRule)}
+	}
+	return &f
+}
+
+// keep reports whether path, a file or a directory, passes the filter.
+// Include patterns only apply to files, a directory is kept as long as
+// nothing excludes it.  It is safe for concurrent use.
+func (f *walkFilter) keep(p string, isDir bool) bool {
+	if p == f.root {
+		return true
+	}
+	rel, err := relPath(f.root, p)
+	if err != nil {
+		return true
+	}
+	segs := strings.Split(rel, "/")
+	if f.maxDepth > 0 && len(segs) > f.maxDepth {
+		return false
+	}
+	for i, seg := range segs {
+		if f.skipHidden && strings.HasPrefix(seg, ".") {
+			return false
+		}
+		for _, pat := range f.exclude {
+			if matchPattern(pat, segs[:i+1]) {
+				return false
+			}
+		}
+	}
+	if f.ignores != nil && f.ignores.ignored(segs, isDir) {
+		return false
+	}
+	if isDir || len(f.include) == 0 {
+		return true
+	}
+	for _, pat := range f.include {
+		if matchPattern(pat, segs) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPattern matches pat against the path made of segs, either
+// against its last segment or, when pat contains a slash, the lot.
+func matchPattern(pat string, segs []string) bool {
+	anchored := strings.Contains(pat, "/")
+	pat = strings.TrimPrefix(pat, "/")
+	if !anchored {
+		ok, _ := path.Match(pat, segs[len(segs)-1])
+		return ok
+	}
+	return matchSegments(strings.Split(pat, "/"), segs)
+}
+
+// matchSegments matches pattern segments against path segments, a **
+// pattern segment matches zero or more path segments.
+func matchSegments(pat, segs []string) bool {
+	if len(pat) == 0 {
+		return len(segs) == 0
+	}
+	if pat[0] == "**" {
+		for i := 0; i <= len(segs); i++ {
+			if matchSegments(pat[1:], segs[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segs) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pat[0], segs[0])
+	return ok && matchSegments(pat[1:], segs[1:])
+}
+
+// ignoreRule is a single line of a .gitignore file.
+type ignoreRule struct {
+	pattern string
+	negate  bool
+	dirOnly bool
+}
+
+// matches reports whether the rule matches the path made of segs,
+// relative to the directory of the .gitignore it came from.
+func (r ignoreRule) matches(segs []string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	return matchPattern(r.pattern, segs)
+}
+
+// gitignore applies the .gitignore files found under root.  They are
+// read lazily, the first time a path below their directory is judged.
+type gitignore struct {
+	fsys fs.FS
+	root string
+
+	mu    sync.Mutex
+	rules map[string][]ignoreRule // by slash separated directory, "" is root
+}
+
+// ignored reports whether the path made of segs is ignored, either
+// itself or because one of the directories above it is.  As with git,
+// nothing below an ignored directory can be re-included.
+func (g *gitignore) ignored(segs []string, isDir bool) bool {
+	for i := range segs {
+		if segs[i] == ".git" {
+			return true
+		}
+		if g.match(segs[:i+1], isDir || i < len(segs)-1) {
+			return true
+		}
+	}
+	return false
+}
+
+// match applies the rules of every directory above segs, the last
+// matching rule deciding, the deepest .gitignore taking precedence.
+func (g *gitignore) match(segs []string, isDir bool) bool {
+	ignored := false
+	for i := range segs {
+		for _, r := range g.load(strings.Join(segs[:i], "/")) {
+			if r.matches(segs[i:], isDir) {
+				ignored = !r.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// load returns the rules of the .gitignore in dir, reading it at most once.
+func (g *gitignore) load(dir string) []ignoreRule {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if rules, ok := g.rules[dir]; ok {
+		return rules
+	}
+
+	name := filepath.Join(g.root, filepath.FromSlash(dir), ".gitignore")
+	if g.fsys != nil {
+		name = path.Join(g.root, dir, ".gitignore")
+	}
+	var rules []ignoreRule
+	if f, err := openFile(g.fsys, name); err == nil {
+		rules = parseGitignore(f)
+		f.Close()
+	}
+	g.rules[dir] = rules
+	return rules
+}
+
+// parseGitignore reads the rules of a .gitignore file.  Blank lines
+// and comments are skipped, a leading ! negates a rule, a trailing /
+// restricts it to directories and a leading / anchors it to the
+// directory of the file.
+func parseGitignore(r io.Reader) []ignoreRule {
+	var rules []ignoreRule
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var r ignoreRule
+		if strings.HasPrefix(line, "!") {
+			r.negate = true
+			line = line[1:]
+		}
+		line = strings.TrimPrefix(line, "\\")
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		r.pattern = line
+		rules = append(rules, r)
+	}
+	return rules
+}
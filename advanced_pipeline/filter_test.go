@@ -0,0 +1,249 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pat, path string
+		want      bool
+	}{
+		// without a slash the last segment is matched, at any depth.
+		{"*.go", "main.go", true},
+		{"*.go", "a/b/main.go", true},
+		{"*.go", "main.go/readme", false},
+		{"vendor", "a/vendor", true},
+		{"vendor", "vendor/a", false},
+		// with one the whole path is, from the root.
+		{"a/*.go", "a/main.go", true},
+		{"a/*.go", "b/a/main.go", false},
+		{"/main.go", "main.go", true},
+		{"/main.go", "a/main.go", false},
+		{"a/*", "a/b/c", false},
+		// ** stands for any number of segments, none included.
+		{"**/main.go", "main.go", true},
+		{"**/main.go", "a/b/main.go", true},
+		{"a/**", "a/b/c", true},
+		{"a/**", "a", true},
+		{"a/**/z", "a/z", true},
+		{"a/**/z", "a/b/c/z", true},
+		{"a/**/z", "a/b/c/y", false},
+		{"a/**/z", "b/a/z", false},
+		{"**/b/**", "a/b/c", true},
+		{"**/b/**", "a/c", false},
+	}
+	for _, tt := range tests {
+		if got := matchPattern(tt.pat, strings.Split(tt.path, "/")); got != tt.want {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", tt.pat, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestMatchSegments(t *testing.T) {
+	tests := []struct {
+		pat, segs []string
+		want      bool
+	}{
+		{nil, nil, true},
+		{nil, []string{"a"}, false},
+		{[]string{"a"}, nil, false},
+		{[]string{"**"}, nil, true},
+		{[]string{"**", "**"}, []string{"a"}, true},
+		{[]string{"[ab]", "c?"}, []string{"b", "cd"}, true},
+		{[]string{"[ab]", "c?"}, []string{"b", "cde"}, false},
+	}
+	for _, tt := range tests {
+		if got := matchSegments(tt.pat, tt.segs); got != tt.want {
+			t.Errorf("matchSegments(%q, %q) = %v, want %v", tt.pat, tt.segs, got, tt.want)
+		}
+	}
+}
+
+func TestWalkFilterKeep(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter walkFilter
+		path   string
+		isDir  bool
+		want   bool
+	}{
+		{"empty", walkFilter{}, "root/a/b/c.txt", false, true},
+		{"root", walkFilter{include: []string{"*.go"}}, "root", true, true},
+		{"included", walkFilter{include: []string{"*.go"}}, "root/a/main.go", false, true},
+		{"not included", walkFilter{include: []string{"*.go"}}, "root/a/readme", false, false},
+		{"directory despite include", walkFilter{include: []string{"*.go"}}, "root/a", true, true},
+		{"excluded", walkFilter{exclude: []string{"*.log"}}, "root/a.log", false, false},
+		{"below an excluded directory", walkFilter{exclude: []string{"vendor"}}, "root/vendor/x/main.go", false, false},
+		{"exclude wins", walkFilter{include: []string{"*.go"}, exclude: []string{"gen_*"}}, "root/gen_x.go", false, false},
+		{"hidden file", walkFilter{skipHidden: true}, "root/a/.env", false, false},
+		{"below a hidden directory", walkFilter{skipHidden: true}, "root/.git/config", false, false},
+		{"not hidden", walkFilter{skipHidden: true}, "root/a.b/c", false, true},
+		{"depth 1 of 1", walkFilter{maxDepth: 1}, "root/a", false, true},
+		{"depth 2 of 1", walkFilter{maxDepth: 1}, "root/a/b", false, false},
+		{"depth 2 of 2", walkFilter{maxDepth: 2}, "root/a/b", false, true},
+		{"depth 3 of 2", walkFilter{maxDepth: 2}, "root/a/b/c", true, false},
+		{"no depth limit", walkFilter{maxDepth: 0}, "root/a/b/c/d/e", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.filter.forRoot(nil, "root")
+			if got := f.keep(tt.path, tt.isDir); got != tt.want {
+				t.Errorf("keep(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxDepthWalk(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"one":        "1",
+		"a/two":      "2",
+		"a/b/three":  "3",
+		"a/b/c/four": "4",
+	})
+	for depth, want := range map[int][]string{
+		0: {"a/b/c/four", "a/b/three", "a/two", "one"},
+		1: {"one"},
+		2: {"a/two", "one"},
+		3: {"a/b/three", "a/two", "one"},
+	} {
+		sums, err := md5All(root, withMaxDepth(depth))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for path := range sums {
+			rel, err := relPath(root, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, rel)
+		}
+		if slices.Sort(got); !slices.Equal(got, want) {
+			t.Errorf("max depth %d: got %v, want %v", depth, got, want)
+		}
+	}
+}
+
+func TestGitignore(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		".gitignore": strings.Join([]string{
+			"# build output",
+			"*.log",
+			"!keep.log",
+			"build/",
+			"!build/keep.txt",
+			"/top.txt",
+			`\!bang`,
+			"",
+		}, "\n"),
+		"sub/.gitignore": "!*.log\ntop.txt\n",
+	})
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"main.go", false, true},
+		{"a.log", false, false},
+		{"deep/a.log", false, false},
+		{"keep.log", false, true},
+		{"deep/keep.log", false, true},
+		// a directory only rule leaves files of that name alone.
+		{"build", true, false},
+		{"deep/build", false, true},
+		// nothing below an ignored directory comes back.
+		{"build/keep.txt", false, false},
+		{"build/x/y.go", false, false},
+		// an anchored rule applies only to its own directory.
+		{"top.txt", false, false},
+		{"deep/top.txt", false, true},
+		{"!bang", false, false},
+		// the deeper .gitignore overrides the one above.
+		{"sub/a.log", false, true},
+		{"sub/top.txt", false, false},
+		{"sub/deeper/top.txt", false, false},
+		{".git", true, false},
+		{".git/config", false, false},
+	}
+	f := walkFilter{gitignore: true}.forRoot(nil, root)
+	for _, tt := range tests {
+		if got := f.keep(filepath.Join(root, filepath.FromSlash(tt.path)), tt.isDir); got != tt.want {
+			t.Errorf("keep(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestParseGitignore(t *testing.T) {
+	root := writeFiles(t, map[string]string{".gitignore": "# comment\n\n*.o  \n!keep.o\nout/\n\\#hash\n"})
+	f, err := os.Open(filepath.Join(root, ".gitignore"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rules := parseGitignore(f)
+	want := []ignoreRule{
+		{pattern: "*.o"},
+		{pattern: "keep.o", negate: true},
+		{pattern: "out", dirOnly: true},
+		{pattern: "#hash"},
+	}
+	if !slices.Equal(rules, want) {
+		t.Errorf("got %+v, want %+v", rules, want)
+	}
+}
+
+func TestWalkSymlinkCycles(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "a")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		filepath.Join(root, "alias"): dir,  // another name for a, walked
+		filepath.Join(dir, "up"):     root, // back to an ancestor, a cycle
+		filepath.Join(dir, "self"):   ".",  // a itself, a cycle
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("symlinks unavailable: %v", err)
+		}
+	}
+
+	tests := []struct {
+		policy symlinkPolicy
+		want   []string
+	}{
+		{symlinkSkip, []string{file}},
+		{symlinkFollow, []string{file, filepath.Join(root, "alias", "file")}},
+	}
+	for _, tt := range tests {
+		result := make(chan []string, 1)
+		go func() {
+			sums, err := md5All(root, withSymlinks(tt.policy))
+			if err != nil {
+				t.Error(err)
+			}
+			result <- slices.Sorted(maps.Keys(sums))
+		}()
+		select {
+		case got := <-result:
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("policy %d: got %v, want %v", tt.policy, got, tt.want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("policy %d: the walk is going round a cycle", tt.policy)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// walkTree walks root calling fn for every file and directory, as
+// filepath.Walk does.  With a nil fsys root is on disk, otherwise it is
+// a slash separated path within fsys, "." being the top.
+func walkTree(fsys fs.FS, root string, fn filepath.WalkFunc) error {
+	if fsys == nil {
+		return filepath.Walk(root, fn)
+	}
+	return fs.WalkDir(fsys, root, func(path string, d fs.DirEntry, err error) error {
+		var info fs.FileInfo
+		if d != nil {
+			var ierr error
+			if info, ierr = d.Info(); err == nil {
+				err = ierr
+			}
+		}
+		return fn(path, info, err)
+	})
+}
+
+// openFile opens name on disk, or within fsys when it is not nil.
+func openFile(fsys fs.FS, name string) (fs.File, error) {
+	if fsys == nil {
+		return os.Open(name)
+	}
+	return fsys.Open(name)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"sort"
+)
+
+// hashFactory returns a fresh hash.Hash, every file digested gets
+// its own instance as a hash.Hash is not safe for concurrent use.
+type hashFactory func() hash.Hash
+
+// algorithms maps the names accepted on the command line to their
+// hash factories.  md5 is the default.
+var algorithms = map[string]hashFactory{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"crc32":  func() hash.Hash { return crc32.NewIEEE() },
+	"fnv":    func() hash.Hash { return fnv.New64a() },
+}
+
+// lookupHash returns the hash factory registered under name.
+func lookupHash(name string) (hashFactory, error) {
+	f, ok := algorithms[name]
+	if !ok {
+		names := make([]string, 0, len(algorithms))
+		for n := range algorithms {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown hash algorithm %q, expected one of %v", name, names)
+	}
+	return f, nil
+}
+
+// hasher streams files through a hash, a pooled buffer at a time,
+// rather than reading them into memory whole.
+type hasher struct {
+	newHash      hashFactory
+	buffers      *bufferPool
+	cache        *digestCache // nil when caching is disabled
+	stats        *stats
+	partial      int64 // when > 0 only this many bytes from each end are read
+	chunk        int64 // when > 0 files are digested as a tree of chunks this size
+	chunkWorkers int   // goroutines hashing the chunks of a single file
+	recover      bool  // digest turns panics into errors
+	fsys         fs.FS // nil reads from disk
+}
+
+// sum digests the file en describes, or the target path of a symlink
+// walked under symlinkTarget.  Files the cache knows to be
+// unchanged are not read, otherwise it blocks until a read buffer is
+// free, giving up with errDigestCancelled if done is closed first.
+func (h *hasher) sum(done <-chan struct{}, en entry) ([]byte, error) {
+	if en.link != "" {
+		defer h.stats.hashed.Add(1)
+		d := h.newHash()
+		io.WriteString(d, en.link)
+		return d.Sum(nil), nil
+	}
+	if h.cache != nil {
+		if sum, ok := h.cache.lookup(en.path, en.info); ok {
+			h.stats.cacheHits.Add(1)
+			h.stats.hashed.Add(1)
+			return sum, nil
+		}
+		h.stats.cacheMisses.Add(1)
+	}
+
+	defer h.stats.hashed.Add(1)
+	sum, err := h.read(done, en.path, en.info.Size())
+	if err != nil {
+		return nil, err
+	}
+	h.cache.store(en.path, en.info, sum)
+	return sum, nil
+}
+
+// partialReader reads the first and last n bytes of f.  Files that
+// cannot be read at an offset, such as those in a compressed archive,
+// have the middle read and discarded instead.
+func partialReader(f fs.File, size, n int64) io.Reader {
+	if ra, ok := f.(io.ReaderAt); ok {
+		return io.MultiReader(
+			io.NewSectionReader(ra, 0, n),
+			io.NewSectionReader(ra, size-n, n),
+		)
+	}
+	return io.MultiReader(
+		io.LimitReader(f, n),
+		readerFunc(func(p []byte) (int, error) {
+			if _, err := io.CopyN(io.Discard, f, size-2*n); err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		}),
+		io.LimitReader(f, n),
+	)
+}
+
+// readerFunc adapts a function to io.Reader.
+type readerFunc func(p []byte) (int, error)
+
+// Read implements io.Reader.
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
+
+// read streams the file at path, of the given size, through a new
+// hash.  A partial hasher reads only the head and tail of files large
+// enough for that to be cheaper than reading them whole, a chunked one
+// hands over to chunkedRead.
+func (h *hasher) read(done <-chan struct{}, path string, size int64) ([]byte, error) {
+	if h.chunk > 0 && h.partial == 0 {
+		return h.chunkedRead(done, path, size)
+	}
+	buf, err := h.buffers.get(done)
+	if err != nil {
+		return nil, err
+	}
+	defer h.buffers.put(buf)
+
+	f, err := openFile(h.fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if h.partial > 0 && size > 2*h.partial {
+		r = partialReader(f, size, h.partial)
+	}
+	d := h.newHash()
+	// both *os.File and io.MultiReader implement io.WriterTo, which
+	// io.CopyBuffer would prefer over buf, the counting reader hides it.
+	if _, err := io.CopyBuffer(d, &countingReader{r, &h.stats.bytesRead}, *buf); err != nil {
+		return nil, err
+	}
+	return d.Sum(nil), nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLookupHash(t *testing.T) {
+	sizes := map[string]int{
+		"md5":    16,
+		"sha1":   20,
+		"sha256": 32,
+		"sha512": 64,
+		"crc32":  4,
+		"fnv":    8,
+	}
+	if len(sizes) != len(algorithms) {
+		t.Fatalf("testing %d algorithms of %d", len(sizes), len(algorithms))
+	}
+	root := writeTree(t, 1, 100)
+	file := filepath.Join(root, "d0", "f0")
+	for name, size := range sizes {
+		t.Run(name, func(t *testing.T) {
+			newHash, err := lookupHash(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := newHash().Size(); n != size {
+				t.Errorf("hash size %d, want %d", n, size)
+			}
+			sums, err := md5All(file, withHash(newHash))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := len(sums[file]); n != size {
+				t.Errorf("digest of %d bytes, want %d", n, size)
+			}
+		})
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sums, err := md5All(file, withHash(sha256.New))
+	if want := sha256.Sum256(data); err != nil || string(sums[file]) != string(want[:]) {
+		t.Errorf("got %x, %v, want %x", sums[file], err, want)
+	}
+
+	_, err = lookupHash("md4")
+	if err == nil || !strings.Contains(err.Error(), `"md4"`) || !strings.Contains(err.Error(), "[crc32 fnv md5 sha1 sha256 sha512]") {
+		t.Errorf("got %v, want md4 rejected with the sorted names", err)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// inode is always zero where the platform does not expose one, the
+// cache then relies on size and modification time alone.
+func inode(info os.FileInfo) uint64 {
+	return 0
+}
@@ -3,11 +3,19 @@ package main
 import (
 	"crypto/md5"
 	"errors"
+	"flag"
 	"fmt"
-	"io/ioutil"
+	"io/fs"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/symonk/concurrency/pipeline"
 )
 
 // main demonstrates a much more advanced pipeline example.
@@ -23,108 +31,772 @@ import (
 //
 // The example outlined below checks the checksum of all the files
 // in this directory, a few .txt files.
+//
+// By default a fixed pool of digesters (GOMAXPROCS) is used, the
+// -unbounded flag restores the one goroutine per file behaviour.
+//
+// The digests are written as a manifest (-format text, json or csv)
+// to stdout or -o.  With -verify the tree is instead checked against
+// an existing manifest, like md5sum -c, exiting non-zero unless every
+// file is OK.
+//
+// With -dupes the same stages are composed into a duplicate file
+// finder instead, see findDuplicates.
+//
+// With -tree the digest of every directory is printed instead, the
+// root first.  Given two roots, -tree prints the paths that differ
+// between them, descending only into directories that changed.
 func main() {
+	workers := flag.Int("workers", 0, "number of digester goroutines, 0 uses GOMAXPROCS")
+	unbounded := flag.Bool("unbounded", false, "start one digester goroutine per file")
+	algo := flag.String("hash", "md5", "hash algorithm: md5, sha1, sha256, sha512, crc32 or fnv")
+	bufSize := flag.Int("bufsize", defaultBufferSize, "size in bytes of each read buffer")
+	maxMem := flag.Int("maxmem", defaultMemoryLimit, "maximum bytes of read buffers in use at once")
+	keepGoing := flag.Bool("keep-going", false, "digest every file and report all errors instead of stopping at the first")
+	formatName := flag.String("format", string(formatText), "manifest format: text, json or csv")
+	output := flag.String("o", "", "write the manifest to this file instead of stdout")
+	check := flag.String("verify", "", "verify the tree against this manifest instead of writing one")
+	dupes := flag.Bool("dupes", false, "report groups of duplicate files instead of writing a manifest")
+	include := flag.String("include", "", "comma separated patterns, only matching files are digested")
+	exclude := flag.String("exclude", "", "comma separated patterns of files and directories to skip")
+	maxDepth := flag.Int("max-depth", 0, "skip files more than this many directories deep, 0 is unlimited")
+	noHidden := flag.Bool("no-hidden", false, "skip dot files and directories")
+	gitignore := flag.Bool("gitignore", false, "skip files ignored by .gitignore, and .git itself")
+	symlinks := flag.String("symlinks", "skip", "symlink policy: skip, follow or target")
+	showProgress := flag.Bool("progress", false, "show a live progress line when stderr is a terminal")
+	tree := flag.Bool("tree", false, "print merkle tree directory digests, or the differences between two roots")
+	useCache := flag.Bool("cache", false, "skip files unchanged since the last cached run")
+	cacheFile := flag.String("cache-file", "", "digest cache location, defaults to "+defaultCacheName+" in the root")
+	chunk := flag.Int64("chunk", 0, "digest files as a tree of chunks this many bytes long, hashed in parallel; not comparable with plain digests")
+	chunkWorkers := flag.Int("chunk-workers", 0, "goroutines hashing the chunks of a single file, 0 uses GOMAXPROCS")
+	watchEvery := flag.Duration("watch", 0, "poll the tree at this interval and print changed files until interrupted")
+	recoverPanics := flag.Bool("recover", false, "report a panic while digesting a file as its error rather than crashing")
+	archive := flag.String("archive", "", "digest the contents of this zip or tar archive, the root is a path within it")
+	flag.Parse()
+
 	done := make(chan struct{})
 	defer close(done)
 	root := "."
-	m, err := md5All(root)
+	if flag.NArg() > 0 {
+		root = flag.Arg(0)
+	}
+
+	newHash, err := lookupHash(*algo)
 	if err != nil {
 		panic(err)
 	}
-	fmt.Println(m)
+	opts := []option{withHash(newHash), withReadBuffers(*bufSize, *maxMem)}
+	if !*unbounded {
+		opts = append(opts, withWorkers(*workers))
+	}
+	if *keepGoing {
+		opts = append(opts, withErrorPolicy(collectAll))
+	}
+	links, err := parseSymlinkPolicy(*symlinks)
+	if err != nil {
+		panic(err)
+	}
+	opts = append(opts, withSymlinks(links), withMaxDepth(*maxDepth))
+	if *include != "" {
+		opts = append(opts, withInclude(strings.Split(*include, ",")...))
+	}
+	if *exclude != "" {
+		opts = append(opts, withExclude(strings.Split(*exclude, ",")...))
+	}
+	if *noHidden {
+		opts = append(opts, withoutHidden())
+	}
+	if *gitignore {
+		opts = append(opts, withGitignore())
+	}
+	var st stats
+	opts = append(opts, withStats(&st))
+	rendered := make(chan struct{})
+	if info, err := os.Stderr.Stat(); *showProgress && err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		updates := make(chan progress, 1)
+		opts = append(opts, withProgress(updates))
+		go func() {
+			defer close(rendered)
+			renderProgress(os.Stderr, updates)
+		}()
+	} else {
+		close(rendered)
+	}
+	if *useCache || *cacheFile != "" {
+		opts = append(opts, withCache(*cacheFile))
+	}
+	if *recoverPanics {
+		opts = append(opts, withPanicRecovery())
+	}
+	if *chunk > 0 {
+		opts = append(opts, withChunks(*chunk, *chunkWorkers))
+	}
+	if *archive != "" {
+		fsys, closer, err := openArchive(*archive)
+		if err != nil {
+			panic(err)
+		}
+		defer closer.Close()
+		opts = append(opts, withFS(fsys))
+	}
+	f, err := parseFormat(*formatName)
+	if err != nil {
+		panic(err)
+	}
+
+	if *check != "" {
+		os.Exit(runVerify(root, *check, f, opts...))
+	}
+	if *dupes {
+		os.Exit(runDupes(root, opts...))
+	}
+	if *tree {
+		os.Exit(runTree(flag.Args(), opts...))
+	}
+	if *watchEvery > 0 {
+		runWatch(root, *watchEvery, opts...)
+		return
+	}
+
+	m, err := md5All(root, opts...)
+	<-rendered
+	if err != nil && !*keepGoing {
+		panic(err)
+	}
+	if werr := writeManifest(root, m, f, *output); werr != nil {
+		panic(werr)
+	}
+	if *useCache || *cacheFile != "" {
+		fmt.Fprintf(os.Stderr, "cache: %d hits, %d misses (%.1f%% hit ratio)\n",
+			st.cacheHits.Load(), st.cacheMisses.Load(), 100*st.hitRatio())
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// writeManifest writes the digests md5All produced for root in format
+// f, to the file at path or stdout if path is empty.
+func writeManifest(root string, sums map[string][]byte, f format, path string) error {
+	m, err := newManifest(root, sums)
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		return m.write(os.Stdout, f)
+	}
+	w, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := m.write(w, f); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// runVerify checks root against the manifest at path, printing a
+// verdict per file, and returns the process exit code.
+func runVerify(root, path string, f format, opts ...option) int {
+	r, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	want, err := readManifest(r, f)
+	r.Close()
+	if err != nil {
+		panic(err)
+	}
+
+	verdicts, err := verify(root, want, opts...)
+	if err != nil {
+		panic(err)
+	}
+	code := 0
+	for _, v := range verdicts {
+		fmt.Println(v)
+		if v.status != statusOK {
+			code = 1
+		}
+	}
+	return code
+}
+
+// runDupes prints the duplicate files under root and the space they
+// waste, returning the process exit code.
+func runDupes(root string, opts ...option) int {
+	groups, err := findDuplicates(root, opts...)
+	// under collectAll the error joins the files that could not be
+	// read, the groups found among the rest are still printed.
+	if err != nil && newConfig(opts...).policy == failFast {
+		panic(err)
+	}
+	var files, wasted int64
+	for _, g := range groups {
+		fmt.Printf("%d files of %d bytes, %d bytes wasted:\n", len(g.paths), g.size, g.wasted())
+		for _, p := range g.paths {
+			fmt.Printf("  %s\n", p)
+		}
+		files += int64(len(g.paths) - 1)
+		wasted += g.wasted()
+	}
+	fmt.Printf("%d duplicate files in %d groups, %d bytes wasted\n", files, len(groups), wasted)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// runWatch prints the changes to root, relative to it, as they are
+// found until the process is interrupted.
+func runWatch(root string, interval time.Duration, opts ...option) {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	done := make(chan struct{})
+	go func() {
+		<-stop
+		close(done)
+	}()
 
+	for c := range watch(done, root, interval, opts...) {
+		if rel, err := relPath(root, c.path); err == nil {
+			c.path = rel
+		}
+		fmt.Println(c)
+	}
+}
+
+// runTree prints the directory digests of a single root, or the paths
+// that differ between two, returning the process exit code.
+func runTree(roots []string, opts ...option) int {
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+	trees := make([]*merkleTree, len(roots))
+	for i, root := range roots {
+		t, err := merkleAll(root, opts...)
+		if err != nil {
+			panic(err)
+		}
+		trees[i] = t
+	}
+	if len(trees) == 1 {
+		fmt.Print(formatTree(trees[0]))
+		return 0
+	}
+
+	changed := diffTrees(trees[0], trees[1])
+	for _, path := range changed {
+		fmt.Println(path)
+	}
+	if len(changed) > 0 {
+		return 1
+	}
+	return 0
 }
 
 // result encapsulates the data for a single file
+// the length of sum depends on the hash algorithm in use.
 type result struct {
 	path string
-	sum  [md5.Size]byte
+	info os.FileInfo
+	sum  []byte
 	err  error
 }
 
-// sumFilesStage walks the tree and digests each of the files in a
-// goroutine, the results are sent to it's downstream channel.
-// sumFilesStage will return on the first error.
-func sumFilesStage(done <-chan struct{}, root string) (<-chan result, <-chan error) {
-	out := make(chan result)
+// entry is a single regular file found walking the tree, or the
+// error encountered trying to visit path.
+type entry struct {
+	path string
+	info os.FileInfo
+	err  error
+	link string // the target of a symlink digested under symlinkTarget
+}
+
+// errWalkCancelled stops a walk abandoned because done was closed.
+var errWalkCancelled = errors.New("walking cancelled")
+
+// walkFilesStage walks the tree and sends each of the regular files
+// it visits to its downstream channel.  Directories, sockets, devices
+// and pipes are skipped, symlinks are handled according to links.
+// Paths that cannot be visited are sent downstream with their error
+// and the walk carries on, it is up to the consumer to decide whether
+// to stop.  The walk is abandoned once done is closed.
+//
+// When fsys is not nil the tree is walked within it instead of on
+// disk, symlinks are then always skipped as fs.FS cannot resolve them.
+func walkFilesStage(done <-chan struct{}, fsys fs.FS, root string, links symlinkPolicy) (<-chan entry, <-chan error) {
+	entries := make(chan entry)
 	e := make(chan error, 1)
 
+	go func() {
+		defer close(entries)
+		send := func(en entry) error {
+			select {
+			case entries <- en:
+				return nil
+			case <-done:
+				return errWalkCancelled
+			}
+		}
+
+		// walk walks dir on disk, reporting paths under the logical
+		// name it was reached by, which differs once a symlink has been
+		// followed.  chain holds the real directories walked into so
+		// far, following a link back into any of them is a cycle.
+		var walk func(dir, logical string, chain []string) error
+		walk = func(dir, logical string, chain []string) error {
+			return walkTree(fsys, dir, func(path string, info os.FileInfo, err error) error {
+				actual := path
+				if rel, rerr := filepath.Rel(dir, path); rerr == nil {
+					path = filepath.Join(logical, rel)
+				}
+				if err != nil {
+					return send(entry{path: path, info: info, err: err})
+				}
+
+				mode := info.Mode()
+				switch {
+				case mode.IsRegular():
+					return send(entry{path: path, info: info})
+				case mode&os.ModeSymlink == 0 || links == symlinkSkip || fsys != nil:
+					return nil
+				case links == symlinkTarget:
+					target, err := os.Readlink(actual)
+					return send(entry{path: path, info: info, err: err, link: target})
+				}
+
+				target, err := os.Stat(actual)
+				if err != nil {
+					return send(entry{path: path, info: info, err: err})
+				}
+				if target.Mode().IsRegular() {
+					return send(entry{path: path, info: target})
+				}
+				if !target.IsDir() {
+					return nil
+				}
+				real, err := filepath.EvalSymlinks(actual)
+				if err != nil {
+					return send(entry{path: path, info: info, err: err})
+				}
+				cyclic := func(dir string) bool {
+					return dir == real || strings.HasPrefix(dir, real+string(filepath.Separator))
+				}
+				if cyclic(filepath.Dir(actual)) || slices.ContainsFunc(chain, cyclic) {
+					return nil
+				}
+				return walk(real, path, append(chain[:len(chain):len(chain)], real))
+			})
+		}
+
+		real := root
+		if fsys == nil {
+			if r, err := filepath.EvalSymlinks(root); err == nil {
+				real = r
+			}
+		}
+		e <- walk(root, root, []string{real})
+	}()
+
+	return entries, e
+}
+
+// sumFilesStage digests each of the upstream files in a goroutine,
+// the results are sent to it's downstream channel.
+func sumFilesStage(done <-chan struct{}, upstream <-chan entry, h *hasher) <-chan result {
+	out := make(chan result)
+
 	go func() {
 		var wg sync.WaitGroup
-		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		for en := range upstream {
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
-				data, err := ioutil.ReadFile(path)
 				select {
-				case out <- result{path, md5.Sum(data), err}:
+				case out <- digest(done, en, h):
 				case <-done:
 				}
 			}()
-			select {
-			case <-done:
-				return errors.New("walking cancelled")
-			default:
-				return nil
-			}
-		})
-		go func() {
-			wg.Wait()
-			close(out)
-		}()
-		e <- err
+		}
+		wg.Wait()
+		close(out)
 	}()
 
-	return out, e
+	return out
+}
+
+// digest digests en, entries that failed to walk keep their error
+// and are not read.  With panic recovery on, a panic digesting en is
+// its error, stack trace and all.
+func digest(done <-chan struct{}, en entry, h *hasher) result {
+	r := result{path: en.path, info: en.info, err: en.err}
+	switch {
+	case r.err != nil:
+	case h.recover:
+		r.sum, r.err = pipeline.Protect("digest", en.path, func(string) ([]byte, error) {
+			return h.sum(done, en)
+		})
+	default:
+		r.sum, r.err = h.sum(done, en)
+	}
+	return r
+}
+
+// boundedSumFilesStage is the bounded counterpart to sumFilesStage.
+// Rather than a goroutine per file, upstream is fanned out to a fixed
+// number of digesters and their results merged back in, capping the
+// digests (and open files) in flight at workers regardless of the size
+// of the tree.
+func boundedSumFilesStage(done <-chan struct{}, upstream <-chan entry, workers int, h *hasher) <-chan result {
+	digesters := make([]<-chan result, 0, workers)
+	for _, entries := range pipeline.FanOut(done, upstream, workers) {
+		digesters = append(digesters, pipeline.Map(done, entries, func(en entry) result {
+			return digest(done, en, h)
+		}))
+	}
+	return pipeline.Merge(done, digesters...)
+}
+
+// config holds the tunables of a single md5All run.
+type config struct {
+	workers  int         // 0 starts a goroutine per file
+	newHash  hashFactory // md5 unless overridden
+	bufSize  int         // size of each pooled read buffer
+	memLimit int         // cap on bytes of read buffers in flight
+	policy   errorPolicy // failFast unless overridden
+	cache    bool        // consult and update the digest cache
+	cacheAt  string      // cache file, defaults to defaultCacheName under root
+	stats    *stats      // counters for the run
+	filter   walkFilter  // which of the walked files are digested
+	links    symlinkPolicy
+	progress chan<- progress // nil unless progress is reported
+	fsys     fs.FS           // nil walks the OS filesystem
+	chunk    int64           // chunk size of the tree hash, 0 hashes files whole
+	chunkers int             // goroutines hashing the chunks of one file
+	recover  bool            // turn panics while digesting into errors
+}
+
+// errorPolicy decides what md5All does when a file cannot be digested.
+type errorPolicy int
+
+const (
+	// failFast abandons the run on the first error.
+	failFast errorPolicy = iota
+	// collectAll digests everything it can and reports every failure.
+	collectAll
+)
+
+const (
+	defaultBufferSize  = 64 << 10
+	defaultMemoryLimit = 16 << 20
+)
+
+// newConfig returns the defaults with opts applied.
+func newConfig(opts ...option) config {
+	cfg := config{
+		newHash:  md5.New,
+		bufSize:  defaultBufferSize,
+		memLimit: defaultMemoryLimit,
+		stats:    &stats{},
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// sumFiles starts the walk, filter and digest stages the config asks
+// for.  The returned cache, if not nil, must be saved once the results
+// have been drained.
+func (c config) sumFiles(done <-chan struct{}, root string) (<-chan result, <-chan error, *digestCache) {
+	c.stats.started = time.Now()
+	if c.progress != nil {
+		go reportProgress(done, c.stats, c.progress)
+	}
+	h := c.hasher()
+	entries, e := c.walk(done, root)
+	if c.cache && c.fsys == nil {
+		h.cache = loadCache(c.cachePath(root), h.emptySum())
+	}
+
+	return c.digestStage(done, entries, h), e, h.cache
+}
+
+// walk starts the walk stage followed, when the run uses the cache, by
+// a filter stage dropping it and, if any filtering is configured,
+// another for that, and counts what makes it through.
+func (c config) walk(done <-chan struct{}, root string) (<-chan entry, <-chan error) {
+	entries, e := walkFilesStage(done, c.fsys, root, c.links)
+	if isCache := c.isCacheFile(root); isCache != nil {
+		entries = pipeline.Filter(done, entries, func(en entry) bool {
+			return !isCache(en.path)
+		})
+	}
+	if !c.filter.empty() {
+		f := c.filter.forRoot(c.fsys, root)
+		entries = pipeline.Filter(done, entries, func(en entry) bool {
+			return f.keep(en.path, false)
+		})
+	}
+	return countStage(done, entries, c.stats), e
+}
+
+// hasher returns a hasher for the configured algorithm and buffers.
+func (c config) hasher() *hasher {
+	return &hasher{
+		newHash:      c.newHash,
+		buffers:      newBufferPool(c.bufSize, c.memLimit),
+		stats:        c.stats,
+		fsys:         c.fsys,
+		chunk:        c.chunk,
+		chunkWorkers: c.chunkers,
+		recover:      c.recover,
+	}
+}
+
+// digestStage digests upstream with h, through either the bounded or
+// the goroutine per file stage.
+func (c config) digestStage(done <-chan struct{}, upstream <-chan entry, h *hasher) <-chan result {
+	if c.workers > 0 {
+		return boundedSumFilesStage(done, upstream, c.workers, h)
+	}
+	return sumFilesStage(done, upstream, h)
+}
+
+// cachePath is where the digest cache for root lives.
+func (c config) cachePath(root string) string {
+	if c.cacheAt != "" {
+		return filepath.Clean(c.cacheAt)
+	}
+	if info, err := os.Stat(root); err == nil && !info.IsDir() {
+		root = filepath.Dir(root)
+	}
+	return filepath.Join(root, defaultCacheName)
+}
+
+// isCacheFile returns a function reporting whether a walked path is
+// the digest cache for root or one of its temporary files, nil unless
+// the run uses the cache.  The cache changes as the run saves it, so it
+// is not digested, but without a cache a file of that name is just
+// another file.
+func (c config) isCacheFile(root string) func(path string) bool {
+	if !c.cache || c.fsys != nil {
+		return nil
+	}
+	return isCacheFile(root, c.cachePath(root))
+}
+
+// option configures an md5All run.
+type option func(*config)
+
+// withWorkers bounds md5All to n digester goroutines.
+// n <= 0 uses GOMAXPROCS.
+func withWorkers(n int) option {
+	return func(c *config) {
+		if n <= 0 {
+			n = runtime.GOMAXPROCS(0)
+		}
+		c.workers = n
+	}
+}
+
+// withHash digests files with hashes created by newHash rather
+// than md5.
+func withHash(newHash hashFactory) option {
+	return func(c *config) {
+		c.newHash = newHash
+	}
+}
+
+// withReadBuffers streams files through size byte buffers, with at
+// most limit bytes of buffers in use across all digesters at once.
+// Non-positive values keep the defaults.
+func withReadBuffers(size, limit int) option {
+	return func(c *config) {
+		if size > 0 {
+			c.bufSize = size
+		}
+		if limit > 0 {
+			c.memLimit = limit
+		}
+	}
+}
+
+// withErrorPolicy sets how md5All handles files it fails to digest.
+func withErrorPolicy(p errorPolicy) option {
+	return func(c *config) {
+		c.policy = p
+	}
+}
+
+// withCache skips reading files whose size, modification time and
+// inode match the digest cache at path, recording the digests of those
+// that do not.  An empty path keeps the cache in root.
+func withCache(path string) option {
+	return func(c *config) {
+		c.cache = true
+		c.cacheAt = path
+	}
+}
+
+// withInclude digests only the files matching at least one of the
+// patterns, see walkFilter for the syntax.
+func withInclude(patterns ...string) option {
+	return func(c *config) {
+		c.filter.include = append(c.filter.include, patterns...)
+	}
+}
+
+// withExclude skips files, and the contents of directories, matching
+// any of the patterns, see walkFilter for the syntax.
+func withExclude(patterns ...string) option {
+	return func(c *config) {
+		c.filter.exclude = append(c.filter.exclude, patterns...)
+	}
+}
+
+// withMaxDepth skips files more than n directories below the root,
+// files directly in the root are at depth 1.  n <= 0 is unlimited.
+func withMaxDepth(n int) option {
+	return func(c *config) {
+		c.filter.maxDepth = n
+	}
+}
+
+// withoutHidden skips dot files and the contents of dot directories.
+func withoutHidden() option {
+	return func(c *config) {
+		c.filter.skipHidden = true
+	}
+}
+
+// withGitignore skips files ignored by the .gitignore files in the
+// tree, and the .git directory itself.
+func withGitignore() option {
+	return func(c *config) {
+		c.filter.gitignore = true
+	}
+}
+
+// withSymlinks sets how symlinks found walking the tree are handled.
+func withSymlinks(p symlinkPolicy) option {
+	return func(c *config) {
+		c.links = p
+	}
+}
+
+// withProgress offers progress updates on c while the run is going, see
+// reportProgress.  c is closed once the run returns, it should have a
+// buffer of one so the latest update is not missed.
+func withProgress(c chan<- progress) option {
+	return func(cfg *config) {
+		cfg.progress = c
+	}
+}
+
+// withFS walks and reads files within fsys rather than on disk, root
+// is then a slash separated path within it, "." being the top.  Any
+// fs.FS will do: os.DirFS, fstest.MapFS, a *zip.Reader or a tarFS.
+// Symlinks are skipped and the cache is not used.
+func withFS(fsys fs.FS) option {
+	return func(c *config) {
+		c.fsys = fsys
+	}
+}
+
+// withChunks digests every file as a tree of size byte chunks, hashed
+// by up to workers goroutines per file, see chunkedRead.  This spreads
+// a single large file across cores, but the digests differ from those
+// of the plain algorithm and from those made with other chunk sizes.
+// workers <= 0 uses GOMAXPROCS, size <= 0 hashes files whole.
+func withChunks(size int64, workers int) option {
+	return func(c *config) {
+		if workers <= 0 {
+			workers = runtime.GOMAXPROCS(0)
+		}
+		c.chunk = max(size, 0)
+		c.chunkers = workers
+	}
+}
+
+// withPanicRecovery turns a panic while digesting a file into that
+// file's error, a *pipeline.PanicError with the stack trace, instead
+// of a crash.  The error policy then decides whether the run stops.
+func withPanicRecovery() option {
+	return func(c *config) {
+		c.recover = true
+	}
+}
+
+// withStats records the counters for the run in s.
+func withStats(s *stats) option {
+	return func(c *config) {
+		c.stats = s
+	}
 }
 
 // md5All reads all the files in the current directory and returns
-// a map for each file path (name) and an array of (16) bytes.
+// a map for each file path (name) and its digest.
 // if anything fails, an error is returned.
-func md5All(root string) (map[string][md5.Size]byte, error) {
-	m := make(map[string][md5.Size]byte)
+//
+// Under the collectAll policy the whole tree is walked regardless
+// and the digests that succeeded are returned alongside an error
+// joining every failure, ordered by path.
+//
+// Without options a goroutine is started per file and md5 is used,
+// see withWorkers and withHash for the alternatives.  The digests are
+// 16 bytes for md5 and vary in length for other algorithms.
+//
+// Files are streamed through the hash rather than read whole, so the
+// memory used is bounded by withReadBuffers and not the file sizes.
+//
+// With withCache, unchanged files are not read at all.  With withFS
+// the tree is read from any fs.FS, a zip or tar archive for instance.
+func md5All(root string, opts ...option) (map[string][]byte, error) {
+	cfg := newConfig(opts...)
+	m := make(map[string][]byte)
 	done := make(chan struct{})
 	defer close(done)
 
-	in, errs := sumFilesStage(done, root)
+	in, errs, cache := cfg.sumFiles(done, root)
+	var failed []result
 	for reply := range in {
 		if reply.err != nil {
-			return nil, reply.err
+			if cfg.policy == failFast {
+				cache.save(false)
+				return nil, reply.err
+			}
+			failed = append(failed, reply)
+			continue
 		}
 		m[reply.path] = reply.sum
 	}
 	if err := <-errs; err != nil {
+		cache.save(false)
 		return nil, err
 	}
-	return m, nil
-}
-
-// merge is a generic fan in implementation.
-// it launches N goroutines, dictated by the
-// number of channels in the inbound slice.
-func merge[T any](done <-chan struct{}, inbound ...<-chan T) <-chan T {
-	out := make(chan T)
-	var wg sync.WaitGroup
-	size := len(inbound)
-	wg.Add(size)
-	task := func(c <-chan T) {
-		defer wg.Done()
-		for {
-			select {
-			case out <- <-c:
-			case <-done:
-				return
-			}
+	if err := cache.save(true); err != nil {
+		if cfg.policy == failFast {
+			return nil, err
 		}
+		failed = append(failed, result{path: cache.path, err: err})
 	}
+	return m, joinFailures(failed)
+}
 
-	for _, ch := range inbound {
-		go task(ch)
+// joinFailures joins the errors of failed ordered by path, nil if
+// there are none.
+func joinFailures(failed []result) error {
+	slices.SortFunc(failed, func(a, b result) int {
+		return strings.Compare(a.path, b.path)
+	})
+	joined := make([]error, len(failed))
+	for i, f := range failed {
+		joined[i] = f.err
 	}
-
-	go func() {
-		wg.Wait()
-		close(out)
-	}()
-	return out
+	return errors.Join(joined...)
 }
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hash"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeTree creates files files of size bytes each under a temporary
+// directory, spread over a few subdirectories, and returns its path.
+func writeTree(tb testing.TB, files, size int) string {
+	tb.Helper()
+	root := tb.TempDir()
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 31)
+	}
+	for i := range files {
+		dir := filepath.Join(root, fmt.Sprintf("d%d", i%8))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			tb.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%d", i)), data, 0o644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return root
+}
+
+// writeFiles creates files, slash separated paths mapped to their
+// content, under a temporary directory and returns its path.
+func writeFiles(tb testing.TB, files map[string]string) string {
+	tb.Helper()
+	root := tb.TempDir()
+	for name, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			tb.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return root
+}
+
+// errBadContent is what badHash fails with.
+var errBadContent = errors.New("bad content")
+
+// badHash is md5 refusing any write starting with "bad", so files with
+// that content cannot be digested, as though they could not be read.
+type badHash struct {
+	hash.Hash
+}
+
+// Write implements io.Writer.
+func (h badHash) Write(p []byte) (int, error) {
+	if bytes.HasPrefix(p, []byte("bad")) {
+		return 0, fmt.Errorf("%w: %s", errBadContent, p)
+	}
+	return h.Hash.Write(p)
+}
+
+// newBadHash is the hashFactory for badHash.
+func newBadHash() hash.Hash {
+	return badHash{md5.New()}
+}
+
+func TestCollectAll(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"a/ok":  "fine",
+		"a/bad": "bad a",
+		"b/bad": "bad b",
+		"c":     "fine too",
+	})
+	modes := []struct {
+		name string
+		opts []option
+	}{
+		{"unbounded", nil},
+		{"bounded", []option{withWorkers(2)}},
+	}
+	for _, m := range modes {
+		t.Run(m.name, func(t *testing.T) {
+			opts := append([]option{withHash(newBadHash)}, m.opts...)
+			sums, err := md5All(root, opts...)
+			if sums != nil || !errors.Is(err, errBadContent) {
+				t.Errorf("fail fast: got %v, %v, want no digests and the error", sums, err)
+			}
+
+			sums, err = md5All(root, append(opts, withErrorPolicy(collectAll))...)
+			want := []string{filepath.Join(root, "a", "ok"), filepath.Join(root, "c")}
+			if got := slices.Sorted(maps.Keys(sums)); !slices.Equal(got, want) {
+				t.Errorf("got digests for %v, want %v", got, want)
+			}
+			var joined interface{ Unwrap() []error }
+			if !errors.As(err, &joined) {
+				t.Fatalf("got %v, want the failures joined", err)
+			}
+			errs := joined.Unwrap()
+			if len(errs) != 2 || !strings.Contains(errs[0].Error(), "bad a") || !strings.Contains(errs[1].Error(), "bad b") {
+				t.Errorf("got %v, want a/bad then b/bad", errs)
+			}
+		})
+	}
+}
+
+func TestIrregularFilesSkipped(t *testing.T) {
+	root := writeFiles(t, map[string]string{"file": "data"})
+	file := filepath.Join(root, "file")
+	if err := os.Symlink(file, filepath.Join(root, "link")); err != nil {
+		t.Logf("no symlink: %v", err)
+	}
+	if l, err := net.Listen("unix", filepath.Join(root, "socket")); err == nil {
+		defer l.Close()
+	} else {
+		t.Logf("no socket: %v", err)
+	}
+
+	sums, err := md5All(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := slices.Collect(maps.Keys(sums)); !slices.Equal(got, []string{file}) {
+		t.Errorf("got digests for %v, want only %s", got, file)
+	}
+}
+
+// BenchmarkDigest compares a goroutine per file against a fixed pool
+// of digesters over the same tree.
+func BenchmarkDigest(b *testing.B) {
+	const files, size = 512, 32 << 10
+	root := writeTree(b, files, size)
+	benchmarks := []struct {
+		name string
+		opts []option
+	}{
+		{"unbounded", nil},
+		{"bounded/1", []option{withWorkers(1)}},
+		{"bounded/GOMAXPROCS", []option{withWorkers(runtime.GOMAXPROCS(0))}},
+		{"bounded/64", []option{withWorkers(64)}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			b.SetBytes(files * size)
+			b.ReportAllocs()
+			for range b.N {
+				if _, err := md5All(root, bm.opts...); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
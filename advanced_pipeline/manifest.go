@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// manifest maps slash separated paths, relative to the root that was
+// digested, to their digests.  Relative paths let a manifest written
+// for one directory be verified against a copy of it elsewhere.
+type manifest map[string][]byte
+
+// format is the on-disk encoding of a manifest.
+type format string
+
+const (
+	// formatText is the GNU coreutils md5sum/sha256sum layout.
+	formatText format = "text"
+	// formatJSON is an array of {"path", "digest"} objects.
+	formatJSON format = "json"
+	// formatCSV is a path,digest table with a header row.
+	formatCSV format = "csv"
+)
+
+// parseFormat validates a format name given on the command line.
+func parseFormat(name string) (format, error) {
+	switch f := format(name); f {
+	case formatText, formatJSON, formatCSV:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown manifest format %q, expected text, json or csv", name)
+}
+
+// manifestEntry is a single line of a manifest in its encoded form.
+type manifestEntry struct {
+	Path   string `json:"path"`
+	Digest string `json:"digest"`
+}
+
+// newManifest rewrites the paths md5All returned for root relative to
+// it.
+func newManifest(root string, sums map[string][]byte) (manifest, error) {
+	m := make(manifest, len(sums))
+	for path, sum := range sums {
+		rel, err := relPath(root, path)
+		if err != nil {
+			return nil, err
+		}
+		m[rel] = sum
+	}
+	return m, nil
+}
+
+// relPath returns path relative to root, slash separated.  When root
+// is itself a file its base name is used.
+func relPath(root, path string) (string, error) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return "", err
+	}
+	if rel == "." {
+		rel = filepath.Base(path)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// entries returns the manifest hex encoded and sorted by path, so the
+// same tree always produces the same bytes.
+func (m manifest) entries() []manifestEntry {
+	out := make([]manifestEntry, 0, len(m))
+	for _, path := range slices.Sorted(maps.Keys(m)) {
+		out = append(out, manifestEntry{path, hex.EncodeToString(m[path])})
+	}
+	return out
+}
+
+// write encodes the manifest to w in format f.
+func (m manifest) write(w io.Writer, f format) error {
+	entries := m.entries()
+	switch f {
+	case formatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"path", "digest"})
+		for _, e := range entries {
+			cw.Write([]string{e.Path, e.Digest})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		bw := bufio.NewWriter(w)
+		for _, e := range entries {
+			// coreutils flags names containing a backslash or newline
+			// with a leading backslash and escapes them.
+			path, prefix := e.Path, ""
+			if strings.ContainsAny(path, "\\\n") {
+				path = strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(path)
+				prefix = "\\"
+			}
+			fmt.Fprintf(bw, "%s%s  %s\n", prefix, e.Digest, path)
+		}
+		return bw.Flush()
+	}
+}
+
+// readManifest decodes a manifest in format f from r.
+func readManifest(r io.Reader, f format) (manifest, error) {
+	var entries []manifestEntry
+	switch f {
+	case formatJSON:
+		if err := json.NewDecoder(r).Decode(&entries); err != nil {
+			return nil, err
+		}
+	case formatCSV:
+		records, err := csv.NewReader(r).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		for i, rec := range records {
+			if len(rec) != 2 {
+				return nil, fmt.Errorf("csv manifest line %d: expected 2 fields, got %d", i+1, len(rec))
+			}
+			if i == 0 && rec[0] == "path" {
+				continue
+			}
+			entries = append(entries, manifestEntry{rec[0], rec[1]})
+		}
+	default:
+		var err error
+		if entries, err = readTextManifest(r); err != nil {
+			return nil, err
+		}
+	}
+
+	m := make(manifest, len(entries))
+	for _, e := range entries {
+		sum, err := hex.DecodeString(e.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("manifest entry %q: %w", e.Path, err)
+		}
+		m[e.Path] = sum
+	}
+	return m, nil
+}
+
+// readTextManifest parses the GNU coreutils checksum layout, a hex
+// digest, a space and either a space (text) or '*' (binary) before
+// the name.
+func readTextManifest(r io.Reader) ([]manifestEntry, error) {
+	var entries []manifestEntry
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		escaped := line[0] == '\\'
+		if escaped {
+			line = line[1:]
+		}
+		digest, path, ok := bytes.Cut(line, []byte(" "))
+		if !ok || len(path) < 2 || (path[0] != ' ' && path[0] != '*') {
+			return nil, fmt.Errorf("text manifest line %d: improperly formatted", n)
+		}
+		name := string(path[1:])
+		if escaped {
+			name = unescapeName(name)
+		}
+		entries = append(entries, manifestEntry{name, string(digest)})
+	}
+	return entries, sc.Err()
+}
+
+// unescapeName reverses the escaping applied by write.
+func unescapeName(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			if s[i] == 'n' {
+				b.WriteByte('\n')
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// status is the outcome of verifying a single file.
+type status int
+
+const (
+	// statusOK means the digest matched the manifest.
+	statusOK status = iota
+	// statusFailed means the digest differed, or the file could not be read.
+	statusFailed
+	// statusMissing means the manifest lists a file the tree does not have.
+	statusMissing
+	// statusNew means the tree has a file the manifest does not list.
+	statusNew
+)
+
+// String implements fmt.Stringer, matching md5sum -c where it can.
+func (s status) String() string {
+	switch s {
+	case statusOK:
+		return "OK"
+	case statusFailed:
+		return "FAILED"
+	case statusMissing:
+		return "MISSING"
+	default:
+		return "NEW"
+	}
+}
+
+// verdict is the verification outcome for a single path.
+type verdict struct {
+	path   string
+	status status
+	err    error // why the file could not be digested, if it could not
+}
+
+// String implements fmt.Stringer in the md5sum -c "path: STATUS" form.
+func (v verdict) String() string {
+	if v.err != nil {
+		return fmt.Sprintf("%s: %s (%v)", v.path, v.status, v.err)
+	}
+	return fmt.Sprintf("%s: %s", v.path, v.status)
+}
+
+// verify re-digests root through the pipeline and compares each result
+// against want as it arrives.  Every path in either the manifest or the
+// tree gets a verdict, sorted by path.  Unreadable files are reported
+// as failed rather than stopping the run.
+func verify(root string, want manifest, opts ...option) ([]verdict, error) {
+	cfg := newConfig(opts...)
+	// a cache hit trusts size and mtime, verification must read the bytes.
+	cfg.cache = false
+	done := make(chan struct{})
+	defer close(done)
+
+	in, errs, _ := cfg.sumFiles(done, root)
+	seen := make(map[string]bool, len(want))
+	var verdicts []verdict
+	for reply := range in {
+		path, err := relPath(root, reply.path)
+		if err != nil {
+			return nil, err
+		}
+		seen[path] = true
+
+		expected, listed := want[path]
+		v := verdict{path: path, err: reply.err}
+		switch {
+		case !listed:
+			v.status = statusNew
+		case reply.err != nil || !bytes.Equal(expected, reply.sum):
+			v.status = statusFailed
+		default:
+			v.status = statusOK
+		}
+		verdicts = append(verdicts, v)
+	}
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+
+	for path := range want {
+		if !seen[path] {
+			verdicts = append(verdicts, verdict{path: path, status: statusMissing})
+		}
+	}
+	slices.SortFunc(verdicts, func(a, b verdict) int {
+		return strings.Compare(a.path, b.path)
+	})
+	return verdicts, nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sumOf is the md5 digest of s.
+func sumOf(s string) []byte {
+	sum := md5.Sum([]byte(s))
+	return sum[:]
+}
+
+func TestReadTextManifest(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    []manifestEntry
+		wantErr bool
+	}{
+		{"text mode", "abcd  a.txt\n", []manifestEntry{{"a.txt", "abcd"}}, false},
+		{"binary mode", "abcd *a.txt\n", []manifestEntry{{"a.txt", "abcd"}}, false},
+		{"spaces kept", "abcd  with  spaces \n", []manifestEntry{{"with  spaces ", "abcd"}}, false},
+		{"blank lines", "\nabcd  a\n\nef01  b\n", []manifestEntry{{"a", "abcd"}, {"b", "ef01"}}, false},
+		{"escaped", `\abcd  back\\slash\nnewline` + "\n", []manifestEntry{{"back\\slash\nnewline", "abcd"}}, false},
+		{"unescaped backslash", `abcd  back\slash` + "\n", []manifestEntry{{`back\slash`, "abcd"}}, false},
+		{"no final newline", "abcd  a", []manifestEntry{{"a", "abcd"}}, false},
+		{"no separator", "abcd\n", nil, true},
+		{"no name", "abcd  \n", nil, true},
+		{"bad mode", "abcd xa.txt\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readTextManifest(strings.NewReader(tt.in))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("entry %d is %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestUnescapeName(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{`a\\b`, `a\b`},
+		{`a\nb`, "a\nb"},
+		{`\\n`, `\n`},
+		{`trailing\`, `trailing\`},
+		{`\x`, "x"},
+	}
+	for _, tt := range tests {
+		if got := unescapeName(tt.in); got != tt.want {
+			t.Errorf("unescapeName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestReadCSVManifest(t *testing.T) {
+	want := manifest{"a.txt": {0xab, 0xcd}, "b.txt": {0xef}}
+	for name, in := range map[string]string{
+		"header":    "path,digest\na.txt,abcd\nb.txt,ef\n",
+		"no header": "a.txt,abcd\nb.txt,ef\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := readManifest(strings.NewReader(in), formatCSV)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.EqualFunc(got, want, bytes.Equal) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+
+	for name, in := range map[string]string{
+		"extra field": "path,digest\na.txt,abcd,x\n",
+		"bad digest":  "path,digest\na.txt,xyz\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if got, err := readManifest(strings.NewReader(in), formatCSV); err == nil {
+				t.Errorf("got %v, want an error", got)
+			}
+		})
+	}
+}
+
+func TestManifestRoundTrip(t *testing.T) {
+	m := manifest{
+		"plain.txt":          sumOf("plain"),
+		"sub/with space.txt": sumOf("space"),
+		`back\slash`:         sumOf("backslash"),
+		"new\nline":          sumOf("newline"),
+		`both\` + "\n":       sumOf("both"),
+	}
+	for _, f := range []format{formatText, formatJSON, formatCSV} {
+		t.Run(string(f), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := m.write(&buf, f); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readManifest(&buf, f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.EqualFunc(got, m, bytes.Equal) {
+				t.Errorf("got %q, want %q", got, m)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	want := manifest{
+		"ok.txt":      sumOf("ok"),
+		"changed.txt": sumOf("as it was"),
+		"missing.txt": sumOf("missing"),
+		"bad.txt":     sumOf("bad"),
+	}
+	root := writeFiles(t, map[string]string{
+		"ok.txt":      "ok",
+		"changed.txt": "changed",
+		"new.txt":     "new",
+		"bad.txt":     "bad",
+	})
+	verdicts, err := verify(root, want, withHash(newBadHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []verdict{
+		{path: "bad.txt", status: statusFailed},
+		{path: "changed.txt", status: statusFailed},
+		{path: "missing.txt", status: statusMissing},
+		{path: "new.txt", status: statusNew},
+		{path: "ok.txt", status: statusOK},
+	}
+	if len(verdicts) != len(expected) {
+		t.Fatalf("got %v, want %v", verdicts, expected)
+	}
+	for i, v := range verdicts {
+		if v.path != expected[i].path || v.status != expected[i].status {
+			t.Errorf("got %v, want %v", v, expected[i])
+		}
+	}
+	if !errors.Is(verdicts[0].err, errBadContent) {
+		t.Errorf("got %v, want bad.txt to fail with its error", verdicts[0])
+	}
+	if got := verdicts[0].String(); !strings.HasPrefix(got, "bad.txt: FAILED (") {
+		t.Errorf("got %q, want the md5sum -c form with the error", got)
+	}
+	if got := verdicts[4].String(); got != "ok.txt: OK" {
+		t.Errorf("got %q, want ok.txt: OK", got)
+	}
+}
+
+func TestRunVerify(t *testing.T) {
+	root := writeFiles(t, map[string]string{"a.txt": "a", "sub/b.txt": "b"})
+	tests := []struct {
+		name string
+		m    manifest
+		want int
+	}{
+		{"all ok", manifest{"a.txt": sumOf("a"), "sub/b.txt": sumOf("b")}, 0},
+		{"failed", manifest{"a.txt": sumOf("a"), "sub/b.txt": sumOf("not b")}, 1},
+		{"missing", manifest{"a.txt": sumOf("a"), "sub/b.txt": sumOf("b"), "c.txt": sumOf("c")}, 1},
+		{"new", manifest{"a.txt": sumOf("a")}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "manifest")
+			var buf bytes.Buffer
+			if err := tt.m.write(&buf, formatText); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if code := runVerify(root, path, formatText); code != tt.want {
+				t.Errorf("exited %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/symonk/concurrency/pipeline"
+)
+
+// listing is a fully walked directory and the children the tree
+// includes, symlinks and other irregular files are left out.
+type listing struct {
+	path    string
+	files   []string
+	dirs    []string
+	failed  bool // the directory could not be read, it gets no digest
+	pending int  // children the builder is still waiting on
+}
+
+// walkTreeStage walks the tree like walkFilesStage, sending the regular
+// files downstream to be digested.  Alongside them it sends a listing
+// of each directory as soon as the walk has left it, children first,
+// which is what lets the tree be hashed bottom-up while the digesters
+// are still busy with the rest of it.
+//
+// When fsys is not nil the tree is walked within it instead of on disk.
+func walkTreeStage(done <-chan struct{}, fsys fs.FS, root string) (<-chan entry, <-chan listing, <-chan error) {
+	entries := make(chan entry)
+	listings := make(chan listing)
+	e := make(chan error, 1)
+
+	go func() {
+		defer close(entries)
+		defer close(listings)
+
+		// open holds the directories being walked, innermost last.
+		// The walk is depth first, so once it visits a path outside
+		// of the innermost directory that directory is complete.
+		var open []*listing
+		sep := string(filepath.Separator)
+		if fsys != nil {
+			sep = "/"
+		}
+		within := func(path string, l *listing) bool {
+			return path == l.path || l.path == root || strings.HasPrefix(path, l.path+sep)
+		}
+		closeDirs := func(keep func(*listing) bool) bool {
+			for len(open) > 0 && !keep(open[len(open)-1]) {
+				top := open[len(open)-1]
+				open = open[:len(open)-1]
+				select {
+				case listings <- *top:
+				case <-done:
+					return false
+				}
+			}
+			return true
+		}
+
+		err := walkTree(fsys, root, func(path string, info os.FileInfo, err error) error {
+			if !closeDirs(func(l *listing) bool { return within(path, l) }) {
+				return errors.New("walking cancelled")
+			}
+			isDir := err == nil && info.IsDir()
+			if err == nil && !isDir && !info.Mode().IsRegular() {
+				return nil
+			}
+
+			var parent *listing
+			if len(open) > 0 {
+				parent = open[len(open)-1]
+			}
+			switch {
+			case parent != nil && parent.path == path:
+				// a directory that cannot be read is visited a second
+				// time with the error.
+				parent.failed = true
+			case parent != nil && isDir:
+				parent.dirs = append(parent.dirs, path)
+			case parent != nil:
+				parent.files = append(parent.files, path)
+			}
+			if isDir {
+				open = append(open, &listing{path: path})
+				return nil
+			}
+
+			select {
+			case entries <- entry{path: path, info: info, err: err}:
+				return nil
+			case <-done:
+				return errors.New("walking cancelled")
+			}
+		})
+		if err == nil && !closeDirs(func(*listing) bool { return false }) {
+			err = errors.New("walking cancelled")
+		}
+		e <- err
+	}()
+
+	return entries, listings, e
+}
+
+// merkleTree holds the digest of every file and directory in a tree,
+// keyed by slash separated path relative to the root, which is ".".
+// A directory's digest covers the names and digests of its children,
+// so two trees with the same root digest have the same content.
+type merkleTree struct {
+	sums     map[string][]byte
+	children map[string][]string // directory to its children's paths
+}
+
+// root is the digest of the whole tree.
+func (t *merkleTree) root() []byte {
+	return t.sums["."]
+}
+
+// dirs returns the digest of every directory in the tree.
+func (t *merkleTree) dirs() map[string][]byte {
+	out := make(map[string][]byte, len(t.children))
+	for dir := range t.children {
+		out[dir] = t.sums[dir]
+	}
+	return out
+}
+
+// diffTrees returns the paths that differ between a and b, sorted.
+// It descends only into directories whose digests differ, so identical
+// subtrees are skipped however large they are.  A path is reported at
+// the highest level it can be, a directory present in only one tree is
+// reported without its contents.
+func diffTrees(a, b *merkleTree) []string {
+	var changed []string
+	var walk func(dir string)
+	walk = func(dir string) {
+		names := make(map[string]bool)
+		for _, c := range a.children[dir] {
+			names[c] = true
+		}
+		for _, c := range b.children[dir] {
+			names[c] = true
+		}
+		for _, path := range slices.Sorted(maps.Keys(names)) {
+			sa, inA := a.sums[path]
+			sb, inB := b.sums[path]
+			_, dirA := a.children[path]
+			_, dirB := b.children[path]
+			switch {
+			case inA && inB && dirA == dirB && bytes.Equal(sa, sb):
+			case inA && inB && dirA && dirB:
+				walk(path)
+			default:
+				changed = append(changed, path)
+			}
+		}
+	}
+	if !bytes.Equal(a.root(), b.root()) {
+		walk(".")
+	}
+	return changed
+}
+
+// merkleAll digests root as a merkle tree, see merkleTree.  Files are
+// digested through the pipeline as in md5All and each directory is
+// hashed as soon as its listing and the digests of all its children
+// are in, so the tree is built up while the walk is still going.
+//
+// Under the collectAll policy a directory with a child that failed to
+// digest, and so every directory above it, has no digest, the rest of
+// the tree is returned alongside the errors.
+//
+// The walk filters apply to the tree as they do in md5All, files and
+// directories they drop are left out of their parent's digest, as is
+// the digest cache when one is in use.
+// Symlinks are always skipped.
+func merkleAll(root string, opts ...option) (*merkleTree, error) {
+	cfg := newConfig(opts...)
+	done := make(chan struct{})
+	defer close(done)
+
+	entries, listings, errs := walkTreeStage(done, cfg.fsys, root)
+	isCache := cfg.isCacheFile(root)
+	if isCache == nil {
+		isCache = func(string) bool { return false }
+	}
+	f := cfg.filter.forRoot(cfg.fsys, root)
+	entries = pipeline.Filter(done, entries, func(en entry) bool {
+		return !isCache(en.path) && f.keep(en.path, false)
+	})
+	results := cfg.digestStage(done, entries, cfg.hasher())
+
+	b := &treeBuilder{
+		root:     root,
+		newHash:  cfg.newHash,
+		sums:     make(map[string][]byte),
+		children: make(map[string][]string),
+		parents:  make(map[string]*listing),
+	}
+	var failed []result
+	for results != nil || listings != nil {
+		select {
+		case r, ok := <-results:
+			if !ok {
+				results = nil
+				continue
+			}
+			if r.err != nil {
+				if cfg.policy == failFast {
+					return nil, r.err
+				}
+				failed = append(failed, r)
+				continue
+			}
+			b.resolve(r.path, r.sum)
+		case l, ok := <-listings:
+			if !ok {
+				listings = nil
+				continue
+			}
+			if !f.keep(l.path, true) {
+				continue
+			}
+			l.files = slices.DeleteFunc(l.files, func(p string) bool { return isCache(p) || !f.keep(p, false) })
+			l.dirs = slices.DeleteFunc(l.dirs, func(p string) bool { return !f.keep(p, true) })
+			b.add(l)
+		}
+	}
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+
+	t := &merkleTree{sums: make(map[string][]byte), children: make(map[string][]string)}
+	for path, sum := range b.sums {
+		rel, err := b.rel(path)
+		if err != nil {
+			return nil, err
+		}
+		t.sums[rel] = sum
+	}
+	for dir, children := range b.children {
+		rel, err := b.rel(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range children {
+			rc, err := b.rel(c)
+			if err != nil {
+				return nil, err
+			}
+			t.children[rel] = append(t.children[rel], rc)
+		}
+		if t.children[rel] == nil {
+			t.children[rel] = []string{}
+		}
+	}
+	return t, joinFailures(failed)
+}
+
+// treeBuilder assembles directory digests bottom-up from file digests
+// and directory listings arriving in any order.
+type treeBuilder struct {
+	root     string
+	newHash  hashFactory
+	sums     map[string][]byte   // digests of files and finished directories
+	children map[string][]string // finished directories to their children
+	parents  map[string]*listing // children not yet digested to their listing
+}
+
+// rel returns path relative to the root, the root itself is ".".
+func (b *treeBuilder) rel(path string) (string, error) {
+	if path == b.root {
+		return ".", nil
+	}
+	rel, err := filepath.Rel(b.root, path)
+	return filepath.ToSlash(rel), err
+}
+
+// add registers a directory listing, hashing it straight away if all
+// of its children are already digested.
+func (b *treeBuilder) add(l listing) {
+	if l.failed {
+		return
+	}
+	p := &l
+	for _, c := range slices.Concat(l.files, l.dirs) {
+		if _, ok := b.sums[c]; !ok {
+			b.parents[c] = p
+			p.pending++
+		}
+	}
+	if p.pending == 0 {
+		b.finish(p)
+	}
+}
+
+// resolve records the digest of path and finishes any directory that
+// was only waiting on it.
+func (b *treeBuilder) resolve(path string, sum []byte) {
+	b.sums[path] = sum
+	p, ok := b.parents[path]
+	if !ok {
+		return
+	}
+	delete(b.parents, path)
+	p.pending--
+	if p.pending == 0 {
+		b.finish(p)
+	}
+}
+
+// finish hashes a directory whose children are all digested.  Children
+// are written in name order, each as its kind, the length of its name,
+// its name and its digest, so no two different directories encode the
+// same way.
+func (b *treeBuilder) finish(l *listing) {
+	type child struct {
+		kind byte
+		name string
+		sum  []byte
+	}
+	var cs []child
+	for _, f := range l.files {
+		cs = append(cs, child{'f', filepath.Base(f), b.sums[f]})
+	}
+	for _, d := range l.dirs {
+		cs = append(cs, child{'d', filepath.Base(d), b.sums[d]})
+	}
+	slices.SortFunc(cs, func(x, y child) int {
+		return strings.Compare(x.name, y.name)
+	})
+
+	h := b.newHash()
+	for _, c := range cs {
+		h.Write([]byte{c.kind})
+		binary.Write(h, binary.BigEndian, uint64(len(c.name)))
+		h.Write([]byte(c.name))
+		h.Write(c.sum)
+	}
+	b.children[l.path] = slices.Concat(l.files, l.dirs)
+	b.resolve(l.path, h.Sum(nil))
+}
+
+// formatTree renders the directory digests of t in the text manifest
+// layout, directories marked with a trailing slash.  A tree rooted at a
+// single file has no directories, its root digest is rendered instead.
+func formatTree(t *merkleTree) string {
+	var sb strings.Builder
+	dirs := t.dirs()
+	if len(dirs) == 0 {
+		fmt.Fprintf(&sb, "%x  .\n", t.root())
+	}
+	for _, dir := range slices.Sorted(maps.Keys(dirs)) {
+		fmt.Fprintf(&sb, "%x  %s/\n", dirs[dir], dir)
+	}
+	return sb.String()
+}
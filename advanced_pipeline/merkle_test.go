@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+)
+
+// baseTree is the tree the merkle tests change one thing at a time.
+func baseTree() map[string]string {
+	return map[string]string{
+		"top.txt":       "top",
+		"a/one.txt":     "one",
+		"a/b/two.txt":   "two",
+		"a/b/three.txt": "three",
+		"c/four.txt":    "four",
+	}
+}
+
+// tree writes files, along with an empty directory, and builds their
+// merkle tree, failing t on error.
+func tree(t *testing.T, files map[string]string, opts ...option) *merkleTree {
+	t.Helper()
+	root := writeFiles(t, files)
+	if err := os.Mkdir(filepath.Join(root, "empty"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	mt, err := merkleAll(root, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mt
+}
+
+func TestMerkleIdenticalTrees(t *testing.T) {
+	a, b := tree(t, baseTree()), tree(t, baseTree(), withWorkers(1))
+	if a.root() == nil || !bytes.Equal(a.root(), b.root()) {
+		t.Fatalf("roots %x and %x, want the same digest", a.root(), b.root())
+	}
+	if !maps.EqualFunc(a.dirs(), b.dirs(), bytes.Equal) {
+		t.Errorf("directory digests differ: %v and %v", a.dirs(), b.dirs())
+	}
+	if got := formatTree(a); got != formatTree(b) {
+		t.Errorf("formatted differently:\n%s\n%s", got, formatTree(b))
+	}
+	if diff := diffTrees(a, b); diff != nil {
+		t.Errorf("got differences %v, want none", diff)
+	}
+	if want := []string{".", "a", "a/b", "c", "empty"}; !slices.Equal(slices.Sorted(maps.Keys(a.dirs())), want) {
+		t.Errorf("got directories %v, want %v", slices.Sorted(maps.Keys(a.dirs())), want)
+	}
+}
+
+func TestDiffTrees(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(map[string]string)
+		want   []string
+	}{
+		{"unchanged", func(map[string]string) {}, nil},
+		{"file content", func(m map[string]string) {
+			m["a/b/two.txt"] = "TWO"
+		}, []string{"a/b/two.txt"}},
+		{"file renamed", func(m map[string]string) {
+			m["a/b/2.txt"] = m["a/b/two.txt"]
+			delete(m, "a/b/two.txt")
+		}, []string{"a/b/2.txt", "a/b/two.txt"}},
+		{"file added", func(m map[string]string) {
+			m["c/five.txt"] = "five"
+		}, []string{"c/five.txt"}},
+		{"directory in only one tree", func(m map[string]string) {
+			m["new/deep/x.txt"] = "x"
+			m["new/y.txt"] = "y"
+		}, []string{"new"}},
+		{"directory removed", func(m map[string]string) {
+			delete(m, "a/b/two.txt")
+			delete(m, "a/b/three.txt")
+		}, []string{"a/b"}},
+		{"file became a directory", func(m map[string]string) {
+			delete(m, "top.txt")
+			m["top.txt/inner"] = "top"
+		}, []string{"top.txt"}},
+		{"two subtrees", func(m map[string]string) {
+			m["a/one.txt"] = "ONE"
+			m["c/four.txt"] = "FOUR"
+		}, []string{"a/one.txt", "c/four.txt"}},
+	}
+	base := tree(t, baseTree())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := baseTree()
+			tt.change(m)
+			changed := tree(t, m)
+			if got := diffTrees(base, changed); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if got := diffTrees(changed, base); !slices.Equal(got, tt.want) {
+				t.Errorf("the other way round got %v, want %v", got, tt.want)
+			}
+			if same := bytes.Equal(base.root(), changed.root()); same != (tt.want == nil) {
+				t.Errorf("roots equal is %v, want %v", same, tt.want == nil)
+			}
+		})
+	}
+}
+
+func TestMerkleFailedFile(t *testing.T) {
+	files := baseTree()
+	files["a/b/bad.txt"] = "bad"
+	root := writeFiles(t, files)
+
+	if _, err := merkleAll(root, withHash(newBadHash)); !errors.Is(err, errBadContent) {
+		t.Errorf("fail fast: got %v, want %v", err, errBadContent)
+	}
+
+	mt, err := merkleAll(root, withHash(newBadHash), withErrorPolicy(collectAll))
+	if !errors.Is(err, errBadContent) {
+		t.Fatalf("collect all: got %v, want %v", err, errBadContent)
+	}
+	// the directory and those above it have no digest, the rest do.
+	for _, dir := range []string{".", "a", "a/b"} {
+		if _, ok := mt.sums[dir]; ok {
+			t.Errorf("%s has a digest", dir)
+		}
+	}
+	for _, path := range []string{"c", "top.txt", "a/one.txt", "a/b/two.txt"} {
+		if _, ok := mt.sums[path]; !ok {
+			t.Errorf("%s has no digest", path)
+		}
+	}
+}
+
+// locked fails to open dir, and so to list it, while locked is set.
+type locked struct {
+	fs.FS
+	dir    string
+	locked *atomic.Bool
+}
+
+// Open implements fs.FS.
+func (l locked) Open(name string) (fs.File, error) {
+	if name == l.dir && l.locked.Load() {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return l.FS.Open(name)
+}
+
+func TestMerkleUnreadableDirectory(t *testing.T) {
+	var isLocked atomic.Bool
+	isLocked.Store(true)
+	base := fstest.MapFS{}
+	for name, data := range baseTree() {
+		base[name] = &fstest.MapFile{Data: []byte(data)}
+	}
+	fsys := locked{FS: base, dir: "a/b", locked: &isLocked}
+
+	if _, err := merkleAll(".", withFS(fsys)); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("fail fast: got %v, want %v", err, fs.ErrPermission)
+	}
+
+	mt, err := merkleAll(".", withFS(fsys), withErrorPolicy(collectAll))
+	if !errors.Is(err, fs.ErrPermission) || !strings.Contains(err.Error(), "a/b") {
+		t.Fatalf("collect all: got %v, want a/b to fail", err)
+	}
+	// the directory and those above it have no digest, the rest do.
+	for _, dir := range []string{".", "a", "a/b"} {
+		if _, ok := mt.sums[dir]; ok {
+			t.Errorf("%s has a digest", dir)
+		}
+	}
+	readable, err := merkleAll(".", withFS(base))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"c", "top.txt", "a/one.txt"} {
+		if !bytes.Equal(mt.sums[path], readable.sums[path]) {
+			t.Errorf("%s digest is %x, want %x", path, mt.sums[path], readable.sums[path])
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// progressInterval is how often a progress update is offered.
+const progressInterval = 200 * time.Millisecond
+
+// progress is a snapshot of a run in flight.
+type progress struct {
+	discovered int64
+	hashed     int64
+	bytesRead  int64
+	elapsed    time.Duration
+	throughput float64 // bytes read per second
+	// eta is only known once the walk has finished and so the total
+	// amount of work with it, until then it is zero and walked false.
+	// It goes by the rate files have been hashed at, rather than bytes
+	// read, as cache hits and partial reads leave bytes unread.
+	eta    time.Duration
+	walked bool
+}
+
+// snapshot takes a progress update from s.
+func (s *stats) snapshot() progress {
+	p := progress{
+		discovered: s.discovered.Load(),
+		hashed:     s.hashed.Load(),
+		bytesRead:  s.bytesRead.Load(),
+		elapsed:    time.Since(s.started),
+		walked:     s.walked.Load(),
+	}
+	if secs := p.elapsed.Seconds(); secs > 0 {
+		p.throughput = float64(p.bytesRead) / secs
+	}
+	if p.walked && p.hashed > 0 {
+		remaining := max(p.discovered-p.hashed, 0)
+		p.eta = p.elapsed / time.Duration(p.hashed) * time.Duration(remaining)
+	}
+	return p
+}
+
+// String implements fmt.Stringer as a single progress line.
+func (p progress) String() string {
+	total := fmt.Sprintf("%d", p.discovered)
+	if !p.walked {
+		total += "+"
+	}
+	line := fmt.Sprintf("%d/%s files, %s, %s/s", p.hashed, total, formatBytes(p.bytesRead), formatBytes(int64(p.throughput)))
+	if p.walked {
+		line += fmt.Sprintf(", ETA %s", p.eta.Round(time.Second))
+	}
+	return line
+}
+
+// reportProgress offers a snapshot of s on c every progressInterval
+// until done is closed, then offers a final one and closes c.  Updates
+// are dropped rather than queued when c is not ready, a slow reader
+// sees fewer updates but never holds up the pipeline.
+func reportProgress(done <-chan struct{}, s *stats, c chan<- progress) {
+	defer close(c)
+	offer := func() {
+		select {
+		case c <- s.snapshot():
+		default:
+		}
+	}
+
+	t := time.NewTicker(progressInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			offer()
+		case <-done:
+			offer()
+			return
+		}
+	}
+}
+
+// renderProgress draws each update as a single line, redrawn in place,
+// for a terminal.  It returns once updates is closed, leaving the last
+// line on screen.
+func renderProgress(w io.Writer, updates <-chan progress) {
+	drawn := false
+	for p := range updates {
+		fmt.Fprintf(w, "\r\033[K%s", p)
+		drawn = true
+	}
+	if drawn {
+		fmt.Fprintln(w)
+	}
+}
+
+// formatBytes renders n in binary units.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
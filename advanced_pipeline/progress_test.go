@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotETA(t *testing.T) {
+	tests := []struct {
+		name               string
+		discovered, hashed int64
+		walked             bool
+		want               time.Duration
+	}{
+		{"still walking", 10, 5, false, 0},
+		{"nothing hashed", 10, 0, true, 0},
+		{"half way", 10, 5, true, 10 * time.Second},
+		{"a quarter left", 8, 6, true, 10 * time.Second / 3},
+		{"finished", 10, 10, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &stats{started: time.Now().Add(-10 * time.Second)}
+			s.discovered.Store(tt.discovered)
+			s.hashed.Store(tt.hashed)
+			s.walked.Store(tt.walked)
+			p := s.snapshot()
+			if p.walked != tt.walked {
+				t.Errorf("walked is %v, want %v", p.walked, tt.walked)
+			}
+			if got := p.eta.Round(100 * time.Millisecond); got != tt.want.Round(100*time.Millisecond) {
+				t.Errorf("got an ETA of %v, want %v", p.eta, tt.want)
+			}
+		})
+	}
+}
+
+func TestProgressETAWithCache(t *testing.T) {
+	root := writeTree(t, 16, 4<<10)
+	if _, err := md5All(root, withCache("")); err != nil {
+		t.Fatal(err)
+	}
+	// one file changes, the rest come from the cache unread.
+	changed := filepath.Join(root, "d0", "f0")
+	if err := os.WriteFile(changed, []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	updates := make(chan progress, 64)
+	if _, err := md5All(root, withCache(""), withProgress(updates)); err != nil {
+		t.Fatal(err)
+	}
+	var last progress
+	for p := range updates {
+		last = p
+	}
+	if !last.walked || last.hashed != 16 || last.discovered != 16 {
+		t.Fatalf("last update %+v, want all 16 files walked and hashed", last)
+	}
+	if last.eta != 0 {
+		t.Errorf("finished with an ETA of %v, want 0", last.eta)
+	}
+}
+
+func TestReportProgress(t *testing.T) {
+	t.Run("drops updates nobody reads", func(t *testing.T) {
+		s := &stats{started: time.Now()}
+		updates := make(chan progress, 1)
+		done := make(chan struct{})
+		returned := make(chan struct{})
+		go func() {
+			defer close(returned)
+			reportProgress(done, s, updates)
+		}()
+		// several updates are offered, only the first fits.
+		time.Sleep(3*progressInterval + progressInterval/2)
+		close(done)
+		select {
+		case <-returned:
+		case <-time.After(time.Second):
+			t.Fatal("reportProgress blocked on a channel nobody reads")
+		}
+		var got int
+		for range updates {
+			got++
+		}
+		if got != 1 {
+			t.Errorf("got %d updates, want the 1 the channel holds", got)
+		}
+	})
+
+	t.Run("closes its channel", func(t *testing.T) {
+		s := &stats{started: time.Now()}
+		s.discovered.Store(3)
+		s.hashed.Store(3)
+		s.walked.Store(true)
+		updates := make(chan progress)
+		done := make(chan struct{})
+		go reportProgress(done, s, updates)
+		close(done)
+		timeout := time.After(time.Second)
+		for {
+			select {
+			case p, ok := <-updates:
+				if !ok {
+					return
+				}
+				if p.hashed != 3 || p.eta != 0 {
+					t.Errorf("got the final update %+v, want 3 hashed and no ETA", p)
+				}
+			case <-timeout:
+				t.Fatal("updates not closed after done")
+			}
+		}
+	})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// stats counts what an md5All run did.  It is updated by the stages as
+// they go and is safe for concurrent use.
+type stats struct {
+	cacheHits   atomic.Int64
+	cacheMisses atomic.Int64
+
+	started    time.Time
+	discovered atomic.Int64 // files walked that will be digested
+	walked     atomic.Bool  // the walk has finished, discovered is final
+	hashed     atomic.Int64 // files digested, from the cache or not
+	bytesRead  atomic.Int64
+}
+
+// hitRatio is the fraction of cache lookups that avoided a read.
+func (s *stats) hitRatio() float64 {
+	hits, misses := s.cacheHits.Load(), s.cacheMisses.Load()
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+// countStage passes entries straight through, counting them in s as
+// discovered, and marks the walk finished once upstream is exhausted.
+func countStage(done <-chan struct{}, upstream <-chan entry, s *stats) <-chan entry {
+	out := make(chan entry)
+	go func() {
+		defer close(out)
+		for en := range upstream {
+			if en.err == nil {
+				s.discovered.Add(1)
+			}
+			select {
+			case out <- en:
+			case <-done:
+				return
+			}
+		}
+		s.walked.Store(true)
+	}()
+	return out
+}
+
+// countingReader adds the bytes read through it to n.
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+// Read implements io.Reader.
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/symonk/concurrency/pipeline"
+)
+
+// changeKind is what happened to a file between two polls.
+type changeKind int
+
+const (
+	// changeCreated means the file was not there on the previous poll.
+	changeCreated changeKind = iota
+	// changeModified means the file's content changed.
+	changeModified
+	// changeDeleted means the file is no longer there.
+	changeDeleted
+	// changeFailed means the file could not be digested, or the tree
+	// could not be walked when the path is the root.
+	changeFailed
+)
+
+// String implements fmt.Stringer.
+func (k changeKind) String() string {
+	switch k {
+	case changeCreated:
+		return "created"
+	case changeModified:
+		return "modified"
+	case changeDeleted:
+		return "deleted"
+	default:
+		return "failed"
+	}
+}
+
+// change is a single file that changed between two polls of a watch.
+// old is nil for created files and new for deleted ones.
+type change struct {
+	path string
+	kind changeKind
+	old  []byte
+	new  []byte
+	err  error // set for changeFailed only
+}
+
+// String implements fmt.Stringer as a single line, digests in hex.
+func (c change) String() string {
+	switch c.kind {
+	case changeCreated:
+		return fmt.Sprintf("%s %s %x", c.kind, c.path, c.new)
+	case changeModified:
+		return fmt.Sprintf("%s %s %x -> %x", c.kind, c.path, c.old, c.new)
+	case changeDeleted:
+		return fmt.Sprintf("%s %s %x", c.kind, c.path, c.old)
+	default:
+		return fmt.Sprintf("%s %s: %v", c.kind, c.path, c.err)
+	}
+}
+
+// watchedFile is what a watch remembers about a file between polls.
+type watchedFile struct {
+	size  int64
+	mtime time.Time
+	sum   []byte // nil if the file could not be digested
+	dir   bool   // a directory that could not be listed rather than a file
+}
+
+// unchanged reports whether info still describes the file as it was.
+func (w watchedFile) unchanged(info os.FileInfo) bool {
+	return w.size == info.Size() && w.mtime.Equal(info.ModTime())
+}
+
+// watch polls root every interval until done is closed, sending a
+// change downstream for every file created, modified or deleted since
+// the poll before.  The first poll digests the whole tree as the
+// baseline to compare against and reports only the files it failed to
+// digest, or the root if the walk failed.
+//
+// Each poll runs the usual walk and filter stages, but only files whose
+// size or modification time differ from the previous poll make it on
+// to the digest stage, so an idle tree costs a walk and no reads.  A
+// file whose modification time changed but whose digest did not, one
+// that was only touched, is not reported.  A change that keeps both
+// the size and the modification time is not noticed.
+//
+// A file that cannot be digested, or a directory that cannot be listed,
+// is reported once as failed and tried again when it next changes.  A
+// directory that can be listed again is not reported, the files found
+// in it are.  A poll whose walk fails reports the root as failed and
+// no deletions, as it cannot tell what is gone.
+//
+// The digest cache is not used, the watch keeps its own state.
+func watch(done <-chan struct{}, root string, interval time.Duration, opts ...option) <-chan change {
+	cfg := newConfig(opts...)
+	cfg.cache = false
+	out := make(chan change)
+
+	go func() {
+		defer close(out)
+		send := func(c change) bool {
+			select {
+			case out <- c:
+				return true
+			case <-done:
+				return false
+			}
+		}
+
+		h := cfg.hasher()
+		state := make(map[string]watchedFile)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for first := true; ; first = false {
+			changes, ok := poll(done, cfg, h, root, state)
+			if !ok {
+				return
+			}
+			for _, c := range changes {
+				// the baseline is what is there to begin with, only
+				// what went wrong taking it is news.
+				if first && c.kind != changeFailed {
+					continue
+				}
+				if !send(c) {
+					return
+				}
+			}
+
+			select {
+			case <-t.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// poll walks root once, digesting only the files that differ from
+// state, and updates state to match.  It returns the changes found,
+// sorted by path, or false if done was closed part way.
+func poll(done <-chan struct{}, cfg config, h *hasher, root string, state map[string]watchedFile) ([]change, bool) {
+	seen := make(map[string]bool, len(state))
+	entries, errs := cfg.walk(done, root)
+	entries = pipeline.Filter(done, entries, func(en entry) bool {
+		seen[en.path] = true
+		prev, ok := state[en.path]
+		if en.err != nil {
+			// a failure already reported is only news again once
+			// what failed changes.
+			return !ok || en.info == nil || prev.sum != nil || !prev.unchanged(en.info)
+		}
+		return !ok || !prev.unchanged(en.info)
+	})
+
+	// the filter reads state while the results arrive, so updates wait
+	// until the stages have drained.
+	var changes []change
+	updates := make(map[string]watchedFile)
+	for r := range cfg.digestStage(done, entries, h) {
+		prev, known := state[r.path]
+		if r.err != nil {
+			if r.info != nil {
+				updates[r.path] = watchedFile{size: r.info.Size(), mtime: r.info.ModTime(), dir: r.info.IsDir()}
+			}
+			changes = append(changes, change{path: r.path, kind: changeFailed, old: prev.sum, err: r.err})
+			continue
+		}
+		updates[r.path] = watchedFile{size: r.info.Size(), mtime: r.info.ModTime(), sum: r.sum}
+		switch {
+		case !known || prev.sum == nil:
+			changes = append(changes, change{path: r.path, kind: changeCreated, new: r.sum})
+		case string(prev.sum) != string(r.sum):
+			changes = append(changes, change{path: r.path, kind: changeModified, old: prev.sum, new: r.sum})
+		}
+	}
+
+	select {
+	case <-done:
+		return nil, false
+	default:
+	}
+	maps.Copy(state, updates)
+	if err := <-errs; err != nil {
+		return append(changes, change{path: root, kind: changeFailed, err: err}), true
+	}
+	for path, prev := range state {
+		if !seen[path] {
+			delete(state, path)
+			if !prev.dir {
+				changes = append(changes, change{path: path, kind: changeDeleted, old: prev.sum})
+			}
+		}
+	}
+	slices.SortFunc(changes, func(a, b change) int {
+		return strings.Compare(a.path, b.path)
+	})
+	return changes, true
+}
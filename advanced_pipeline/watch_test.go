@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// unreadable fails to open the files named in it, while still listing
+// them in their directories.
+type unreadable struct {
+	fs.FS
+	names map[string]bool
+}
+
+// Open implements fs.FS.
+func (u unreadable) Open(name string) (fs.File, error) {
+	if u.names[name] {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return u.FS.Open(name)
+}
+
+// replace writes data to path in one go, through a temporary file, so
+// a poll never sees it created empty or part written.
+func replace(t *testing.T, path string, data []byte) {
+	t.Helper()
+	tmp := filepath.Join(t.TempDir(), filepath.Base(path))
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// next returns the next change from c, failing t if none comes.
+func next(t *testing.T, c <-chan change) change {
+	t.Helper()
+	select {
+	case ch, ok := <-c:
+		if !ok {
+			t.Fatal("watch stopped")
+		}
+		return ch
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change reported")
+	}
+	return change{}
+}
+
+// quiet fails t if c reports a change within d.
+func quiet(t *testing.T, c <-chan change, d time.Duration) {
+	t.Helper()
+	select {
+	case ch := <-c:
+		t.Fatalf("unexpected change %v", ch)
+	case <-time.After(d):
+	}
+}
+
+func TestWatchReportsBaselineFailures(t *testing.T) {
+	fsys := unreadable{
+		FS: fstest.MapFS{
+			"good.txt": {Data: []byte("good")},
+			"bad.txt":  {Data: []byte("bad")},
+		},
+		names: map[string]bool{"bad.txt": true},
+	}
+	done := make(chan struct{})
+	defer close(done)
+	changes := watch(done, ".", 10*time.Millisecond, withFS(fsys))
+
+	c := next(t, changes)
+	if c.kind != changeFailed || c.path != "bad.txt" || !errors.Is(c.err, fs.ErrPermission) {
+		t.Fatalf("got %v, want bad.txt failed", c)
+	}
+	// reported once, good.txt was part of the baseline.
+	quiet(t, changes, 100*time.Millisecond)
+}
+
+func TestWatchUnreadableDirectory(t *testing.T) {
+	var isLocked atomic.Bool
+	isLocked.Store(true)
+	fsys := locked{
+		FS: fstest.MapFS{
+			"top.txt":   {Data: []byte("top")},
+			"sub/a.txt": {Data: []byte("a")},
+		},
+		dir:    "sub",
+		locked: &isLocked,
+	}
+	done := make(chan struct{})
+	defer close(done)
+	changes := watch(done, ".", 10*time.Millisecond, withFS(fsys))
+
+	c := next(t, changes)
+	if c.kind != changeFailed || c.path != "sub" || !errors.Is(c.err, fs.ErrPermission) {
+		t.Fatalf("got %v, want sub failed", c)
+	}
+	// neither failed again nor deleted on the polls after.
+	quiet(t, changes, 100*time.Millisecond)
+
+	// once it can be listed its files are new, the directory is not news.
+	isLocked.Store(false)
+	if c := next(t, changes); c.kind != changeCreated || c.path != "sub/a.txt" {
+		t.Fatalf("got %v, want sub/a.txt created", c)
+	}
+	quiet(t, changes, 100*time.Millisecond)
+}
+
+func TestWatchReportsFailedBaselineWalk(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "missing")
+	done := make(chan struct{})
+	defer close(done)
+	changes := watch(done, root, 10*time.Millisecond)
+
+	if c := next(t, changes); c.kind != changeFailed || c.path != root {
+		t.Fatalf("got %v, want %s failed", c, root)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	root := t.TempDir()
+	kept := filepath.Join(root, "kept")
+	if err := os.WriteFile(kept, []byte("one"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	changes := watch(done, root, 10*time.Millisecond)
+	quiet(t, changes, 50*time.Millisecond)
+
+	added := filepath.Join(root, "added")
+	replace(t, added, []byte("new"))
+	if c := next(t, changes); c.kind != changeCreated || c.path != added {
+		t.Fatalf("got %v, want %s created", c, added)
+	}
+
+	replace(t, kept, []byte("two!"))
+	if c := next(t, changes); c.kind != changeModified || c.path != kept {
+		t.Fatalf("got %v, want %s modified", c, kept)
+	}
+
+	if err := os.Remove(added); err != nil {
+		t.Fatal(err)
+	}
+	if c := next(t, changes); c.kind != changeDeleted || c.path != added {
+		t.Fatalf("got %v, want %s deleted", c, added)
+	}
+}
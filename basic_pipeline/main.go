@@ -1,6 +1,14 @@
 package main
 
-import "fmt"
+import (
+	"context"
+	"flag"
+	"fmt"
+	"iter"
+	"runtime"
+
+	"github.com/symonk/concurrency/pipeline"
+)
 
 // main demonstates a pipelining example with 4
 // seperate stages.
@@ -10,59 +18,57 @@ import "fmt"
 // A multiplication stage
 // A bitwise operating stage
 //
-// because they share channel types, we can easily compose them.
+// because each stage takes and returns an int, we can easily
+// compose them, the compiler rejects stages that do not fit.
+//
+// Each stage is fanned out to -workers goroutines.  By default their
+// results are put back in order, -unordered sends each on as soon as it
+// is ready instead, trading the order for throughput.
 func main() {
-	final := stageThree(stageTwo(stageOne(generator())))
-	for v := range final {
-		fmt.Println(v)
+	workers := flag.Int("workers", runtime.GOMAXPROCS(0), "goroutines per stage")
+	unordered := flag.Bool("unordered", false, "let each stage's output leave in any order")
+	flag.Parse()
+
+	opts := []pipeline.StageOption{pipeline.Workers(*workers)}
+	if !*unordered {
+		opts = append(opts, pipeline.Ordered())
+	}
+	h := pipeline.From(pipeline.Each(generator(1_000_000))).
+		Then(stageOne, opts...).
+		Then(stageTwo, opts...).
+		Then(stageThree, opts...).
+		Sink(context.Background(), func(_ context.Context, v int) error {
+			fmt.Println(v)
+			return nil
+		})
+	if err := h.Wait(); err != nil {
+		panic(err)
 	}
 }
 
-// generator yields the values 1->1,000,000
-// it returns a channel to consume its values
-func generator() <-chan int {
-	out := make(chan int)
-	go func() {
-		defer close(out)
-		for i := range 1_000_000 {
-			out <- i
+// generator yields the values 0->n-1
+// to the rest of the pipeline.
+func generator(n int) iter.Seq[int] {
+	return func(yield func(int) bool) {
+		for i := range n {
+			if !yield(i) {
+				return
+			}
 		}
-	}()
-	return out
+	}
 }
 
 // stageOne doubles the numbers from the input stream.
-func stageOne(upstream <-chan int) <-chan int {
-	out := make(chan int)
-	go func() {
-		defer close(out)
-		for in := range upstream {
-			out <- in * 2
-		}
-	}()
-	return out
+func stageOne(_ context.Context, in int) (int, error) {
+	return in * 2, nil
 }
 
 // stageTwo multiples the number by 10
-func stageTwo(upstream <-chan int) <-chan int {
-	out := make(chan int)
-	go func() {
-		defer close(out)
-		for in := range upstream {
-			out <- in * 10
-		}
-	}()
-	return out
+func stageTwo(_ context.Context, in int) (int, error) {
+	return in * 10, nil
 }
 
 // stageThree bit shifts the result
-func stageThree(upstream <-chan int) <-chan int {
-	out := make(chan int)
-	go func() {
-		defer close(out)
-		for in := range upstream {
-			out <- in << 1
-		}
-	}()
-	return out
+func stageThree(_ context.Context, in int) (int, error) {
+	return in << 1, nil
 }
@@ -1,44 +1,82 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"log"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/symonk/concurrency/pipeline"
 )
 
 // main demonstrates the fan in pattern.
 // consolidating data from multiple goroutines.
+//
+// By default which server's status comes next is up to the scheduler,
+// -weights gives each a share instead, "3,2,1" sending three of the
+// first server's statuses for every one of the last while all of them
+// have some waiting.
+//
+// Every status is printed unless -shed is given, the statuses are
+// telemetry so rather than stall the servers behind a slow reader the
+// oldest are then shed once that many are waiting.
 func main() {
-	// invoke a long running io function, three times.
-	a, b, c := someIO(20), someIO(20), someIO(20)
-	fanned := fanIn(a, b, c)
-	for element := range fanned {
-		fmt.Println(element)
+	weights := flag.String("weights", "", "comma separated share of each of the three servers")
+	shed := flag.Int("shed", 0, "drop the oldest statuses once this many are waiting, 0 keeps them all")
+	flag.Parse()
+
+	// invoke a long running io function, three times.  A server that
+	// panics is asked again, a few times at most before giving up.
+	sup := pipeline.NewSupervisor(pipeline.RestartOnPanic, 3)
+	quit := make(chan struct{})
+	defer close(quit)
+	done := sup.Done(quit)
+	a, b, c := someIO(20, sup), someIO(20, sup), someIO(20, sup)
+	defer report(sup)
+	// only one merge may read the servers, a second would take
+	// statuses the first never sees.
+	var merged <-chan status
+	if *weights != "" {
+		w, err := parseWeights(*weights, 3)
+		if err != nil {
+			log.Fatal(err)
+		}
+		merged = pipeline.WeightedMerge(done, []pipeline.Weighted[status]{{C: a, Weight: w[0]}, {C: b, Weight: w[1]}, {C: c, Weight: w[2]}})
+	} else {
+		merged = pipeline.FanIn(done, a, b, c)
+	}
+	if *shed <= 0 {
+		for element := range merged {
+			fmt.Println(element)
+		}
+		return
 	}
 
+	var drops pipeline.Drops
+	for element := range pipeline.Buffer(done, merged, *shed, pipeline.DropOldest, &drops) {
+		fmt.Println(element)
+	}
+	fmt.Printf("%d statuses dropped\n", drops.Count())
 }
 
-// fanIn demonstartes a simple way to fan in multiple channels
-// into one.
-func fanIn(ch ...<-chan status) <-chan status {
-	out := make(chan status)
-	var wg sync.WaitGroup
-	wg.Add(len(ch))
-	for _, channel := range ch {
-		go func(c <-chan status) {
-			defer wg.Done()
-			for v := range c {
-				out <- v
-			}
-		}(channel)
+// parseWeights parses n comma separated weights.
+func parseWeights(s string, n int) ([]int, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != n {
+		return nil, fmt.Errorf("expected %d weights, got %q", n, s)
 	}
-
-	go func() {
-		wg.Wait()
-		close(out)
-	}()
-
-	return out
+	weights := make([]int, n)
+	for i, f := range fields {
+		w, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil {
+			return nil, fmt.Errorf("weight %q: %w", f, err)
+		}
+		weights[i] = w
+	}
+	return weights, nil
 }
 
 // status encapsulates some response from a server
@@ -47,16 +85,29 @@ type status struct {
 	message string
 }
 
-// someIO simulates a long running function.
-func someIO(size int) <-chan status {
+// report logs the panics sup recovered, exiting if it gave up.
+func report(sup *pipeline.Supervisor) {
+	for _, p := range sup.Panics() {
+		log.Print(p)
+	}
+	if err := sup.Err(); err != nil {
+		log.Fatal("giving up: ", err)
+	}
+}
+
+// someIO simulates a long running function, its goroutines recovered
+// by sup.
+func someIO(size int, sup *pipeline.Supervisor) <-chan status {
 	c := make(chan status, size)
 	var wg sync.WaitGroup
 	wg.Add(size)
 	for i := range size {
 		go func(i int) {
 			defer wg.Done()
-			time.Sleep(200 * time.Millisecond)
-			c <- status{code: i, message: fmt.Sprintf("message %d", i)}
+			sup.Run("someIO", func() {
+				time.Sleep(200 * time.Millisecond)
+				c <- status{code: i, message: fmt.Sprintf("message %d", i)}
+			})
 		}(i)
 	}
 	go func() {
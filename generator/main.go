@@ -1,14 +1,33 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"iter"
+)
 
 // main demonstrates how a channel can be used to retrieve
 // values one at a time, limiting the memory usage.
+//
+// Since go 1.23 the same can be done without a goroutine or a
+// channel at all, by returning an iter.Seq.  A range loop
+// over it calls the function for every value, and iter.Pull
+// turns it back into something you ask for one value at a
+// time, just like receiving from the channel.
 func main() {
 	g := generator(10, 20)
 	for i := range g {
 		fmt.Println(i)
 	}
+
+	for i := range sequence(10, 20) {
+		fmt.Println(i)
+	}
+
+	next, stop := iter.Pull(sequence(10, 20))
+	defer stop()
+	for i, ok := next(); ok; i, ok = next() {
+		fmt.Println(i)
+	}
 }
 
 // generator yields the integers between start (inclusive)
@@ -20,7 +39,7 @@ func generator(start, end int) <-chan int {
 		A goroutine is responsible for sending the numbers in
 		and defering the channel close when finished.
 
-		This allows the range loop on line #7 to iterate all
+		This allows the range loop in main to iterate all
 		values and stop iterating when all have been processed.
 	*/
 	c := make(chan int)
@@ -32,3 +51,23 @@ func generator(start, end int) <-chan int {
 	}()
 	return c
 }
+
+// sequence yields the integers between start (inclusive)
+// and end (exclusive) as an iter.Seq.
+func sequence(start, end int) iter.Seq[int] {
+	/*
+		Nothing runs until the sequence is ranged over, and
+		then only in the goroutine doing the ranging.  yield
+		returns false when the loop is broken out of, the
+		generator must return then, which means it can never
+		be left blocked the way a goroutine sending on a
+		channel nobody reads from can.
+	*/
+	return func(yield func(int) bool) {
+		for i := start; i < end; i++ {
+			if !yield(i) {
+				return
+			}
+		}
+	}
+}
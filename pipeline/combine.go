@@ -0,0 +1,104 @@
+package pipeline
+
+// Zip pairs the values of a and b in the order they arrive, the first
+// of a with the first of b and so on, closing once either is exhausted.
+// A value read from one whose partner never comes is dropped.
+func Zip[A, B any](done <-chan struct{}, a <-chan A, b <-chan B) <-chan Pair[A, B] {
+	out := make(chan Pair[A, B])
+	go func() {
+		defer close(out)
+		for {
+			var p Pair[A, B]
+			var ok bool
+			select {
+			case p.Key, ok = <-a:
+				if !ok {
+					return
+				}
+			case <-done:
+				return
+			}
+			select {
+			case p.Value, ok = <-b:
+				if !ok {
+					return
+				}
+			case <-done:
+				return
+			}
+			select {
+			case out <- p:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// CombineLatest sends the latest values of a and b as a pair every
+// time either of them produces one, starting once both have.  It
+// closes once both are exhausted, the last value of the one that ran
+// out first being paired with whatever the other still produces.
+func CombineLatest[A, B any](done <-chan struct{}, a <-chan A, b <-chan B) <-chan Pair[A, B] {
+	out := make(chan Pair[A, B])
+	go func() {
+		defer close(out)
+		var latest Pair[A, B]
+		var haveA, haveB bool
+		// a channel is set to nil once exhausted, a nil channel is never
+		// ready so the select carries on with the other one.
+		for a != nil || b != nil {
+			select {
+			case v, ok := <-a:
+				if !ok {
+					a = nil
+					continue
+				}
+				latest.Key, haveA = v, true
+			case v, ok := <-b:
+				if !ok {
+					b = nil
+					continue
+				}
+				latest.Value, haveB = v, true
+			case <-done:
+				return
+			}
+			if !haveA || !haveB {
+				continue
+			}
+			select {
+			case out <- latest:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Partition splits upstream in two, the values match returns true for
+// going to the first channel and the rest to the second, each in their
+// upstream order.  Both must be read from, as with Tee, or a value
+// bound for the other one holds up both.
+func Partition[T any](done <-chan struct{}, upstream <-chan T, match func(T) bool) (<-chan T, <-chan T) {
+	matched := make(chan T)
+	rest := make(chan T)
+	go func() {
+		defer close(matched)
+		defer close(rest)
+		for v := range OrDone(done, upstream) {
+			out := rest
+			if match(v) {
+				out = matched
+			}
+			select {
+			case out <- v:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return matched, rest
+}
@@ -0,0 +1,152 @@
+package pipeline
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestZip(t *testing.T) {
+	tests := []struct {
+		name string
+		a    []int
+		b    []string
+		want []Pair[int, string]
+	}{
+		{"empty", nil, nil, nil},
+		{"one empty", []int{1, 2}, nil, nil},
+		{"same length", []int{1, 2}, []string{"a", "b"}, []Pair[int, string]{{1, "a"}, {2, "b"}}},
+		{"a longer", []int{1, 2, 3}, []string{"a", "b"}, []Pair[int, string]{{1, "a"}, {2, "b"}}},
+		{"b longer", []int{1}, []string{"a", "b"}, []Pair[int, string]{{1, "a"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			noLeaks(t)
+			done := make(chan struct{})
+			defer close(done)
+			if got := collect(t, Zip(done, Generator(done, tt.a...), Generator(done, tt.b...))); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("cancelled", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		out := Zip(done, Repeat(done, 1), Repeat(done, "a"))
+		<-out
+		close(done)
+		closes(t, out)
+	})
+
+	t.Run("cancelled waiting for a partner", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		out := Zip(done, Repeat(done, 1), stalled[string]())
+		close(done)
+		closes(t, out)
+	})
+}
+
+func TestCombineLatest(t *testing.T) {
+	noLeaks(t)
+	done := make(chan struct{})
+	defer close(done)
+	a, b := make(chan int), make(chan string)
+	out := CombineLatest(done, a, b)
+	next := func(want Pair[int, string]) {
+		t.Helper()
+		select {
+		case got := <-out:
+			if got != want {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+		case <-time.After(wait):
+			t.Fatalf("no pair, want %v", want)
+		}
+	}
+
+	// nothing until both have a value.
+	a <- 1
+	a <- 2
+	b <- "x"
+	next(Pair[int, string]{2, "x"})
+	a <- 3
+	next(Pair[int, string]{3, "x"})
+	b <- "y"
+	next(Pair[int, string]{3, "y"})
+	// a's last value stays paired with whatever b still sends.
+	close(a)
+	b <- "z"
+	next(Pair[int, string]{3, "z"})
+	close(b)
+	closes(t, out)
+
+	t.Run("one never sends", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		defer close(done)
+		if got := collect(t, CombineLatest(done, Generator(done, 1, 2), Generator[string](done))); len(got) != 0 {
+			t.Errorf("got %v, want nothing", got)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		out := CombineLatest(done, Repeat(done, 1), Repeat(done, "a"))
+		<-out
+		close(done)
+		closes(t, out)
+	})
+}
+
+func TestPartition(t *testing.T) {
+	even := func(v int) bool { return v%2 == 0 }
+	tests := []struct {
+		name          string
+		upstream      []int
+		matched, rest []int
+	}{
+		{"empty", nil, nil, nil},
+		{"all matched", []int{2, 4}, []int{2, 4}, nil},
+		{"none matched", []int{1, 3}, nil, []int{1, 3}},
+		{"split in order", []int{1, 2, 3, 4, 5, 6}, []int{2, 4, 6}, []int{1, 3, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			noLeaks(t)
+			done := make(chan struct{})
+			defer close(done)
+			matched, rest := Partition(done, Generator(done, tt.upstream...), even)
+			var gotMatched, gotRest []int
+			var wg sync.WaitGroup
+			wg.Add(2)
+			// both are read at once, one left unread holds up the other.
+			go func() {
+				defer wg.Done()
+				gotMatched = slices.Collect(Seq(done, matched))
+			}()
+			go func() {
+				defer wg.Done()
+				gotRest = slices.Collect(Seq(done, rest))
+			}()
+			wg.Wait()
+			if !slices.Equal(gotMatched, tt.matched) || !slices.Equal(gotRest, tt.rest) {
+				t.Errorf("got %v and %v, want %v and %v", gotMatched, gotRest, tt.matched, tt.rest)
+			}
+		})
+	}
+
+	t.Run("cancelled", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		matched, rest := Partition(done, Repeat(done, 2, 1), even)
+		<-matched
+		// the next value, bound for rest, is left unread.
+		close(done)
+		closes(t, matched)
+		closes(t, rest)
+	})
+}
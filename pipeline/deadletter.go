@@ -0,0 +1,103 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+)
+
+// ErrorPolicy decides what a Flow stage does with an input it fails on.
+type ErrorPolicy int
+
+const (
+	// Stop cancels the whole flow, Wait returns the error.
+	Stop ErrorPolicy = iota
+	// Skip drops the input and carries on.
+	Skip
+	// DeadLetter drops the input and carries on, recording the input,
+	// the stage and the error in a DeadLetters for later inspection or
+	// Replay.  Inputs the stage still holds when the flow stops, taken
+	// in but not yet passed on, are recorded too, with the error that
+	// stopped it.
+	DeadLetter
+)
+
+// String implements fmt.Stringer.
+func (p ErrorPolicy) String() string {
+	switch p {
+	case Stop:
+		return "stop"
+	case Skip:
+		return "skip"
+	default:
+		return "dead-letter"
+	}
+}
+
+// Letter is an input a stage failed on.
+type Letter struct {
+	Stage string // as given with Named, else "stage N" counting from 1, or "sink"
+	Input any
+	Err   error
+}
+
+// DeadLetters collects the inputs stages failed on under the
+// DeadLetter policy.  It is safe for concurrent use, one DeadLetters
+// can serve several stages and its zero value is ready to use.
+type DeadLetters struct {
+	mu      sync.Mutex
+	letters []Letter
+}
+
+// add records a failed input.
+func (d *DeadLetters) add(l Letter) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.letters = append(d.letters, l)
+}
+
+// Letters returns the failed inputs in the order they failed.
+func (d *DeadLetters) Letters() []Letter {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Letter(nil), d.letters...)
+}
+
+// Replay is a Source of the inputs stage failed on, taking them out of
+// d.  Feeding it to a Flow that starts at the same stage retries them,
+// and with the same DeadLetters those that fail again are put back:
+//
+//	again := pipeline.Then(pipeline.From(pipeline.Replay[string](letters, "parse")), parse,
+//		pipeline.Named("parse"), pipeline.OnError(pipeline.DeadLetter, letters))
+//
+// Inputs that are not a T are left in place.  If the flow stops before
+// every input has been replayed, those it did not get to go back in d,
+// ahead of any that failed since, and those already emitted but still
+// held by a stage keeping dead letters are recorded by it, so none
+// taken from d is lost.
+func Replay[T any](d *DeadLetters, stage string) Source[T] {
+	return func(ctx context.Context, emit func(T) error) error {
+		d.mu.Lock()
+		var taken []Letter
+		kept := d.letters[:0]
+		for _, l := range d.letters {
+			if _, ok := l.Input.(T); ok && l.Stage == stage {
+				taken = append(taken, l)
+				continue
+			}
+			kept = append(kept, l)
+		}
+		clear(d.letters[len(kept):])
+		d.letters = kept
+		d.mu.Unlock()
+
+		for i, l := range taken {
+			if err := emit(l.Input.(T)); err != nil {
+				d.mu.Lock()
+				d.letters = append(taken[i:], d.letters...)
+				d.mu.Unlock()
+				return err
+			}
+		}
+		return nil
+	}
+}
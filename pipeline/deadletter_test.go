@@ -0,0 +1,176 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// failOn is a stage failing on the inputs in bad.
+func failOn(bad ...int) Stage[int, int] {
+	return func(_ context.Context, v int) (int, error) {
+		if slices.Contains(bad, v) {
+			return 0, errOdd
+		}
+		return v, nil
+	}
+}
+
+// inputs returns the inputs of letters as ints.
+func inputs(letters []Letter) []int {
+	var out []int
+	for _, l := range letters {
+		out = append(out, l.Input.(int))
+	}
+	return out
+}
+
+func TestErrorPolicy(t *testing.T) {
+	tests := []struct {
+		policy  ErrorPolicy
+		want    []int
+		err     error
+		letters []int
+	}{
+		{Stop, nil, errOdd, nil},
+		{Skip, []int{0, 1, 3, 5}, nil, nil},
+		{DeadLetter, []int{0, 1, 3, 5}, nil, []int{2, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			noLeaks(t)
+			var letters DeadLetters
+			got, err := run(t, From(upTo(6)).Then(failOn(2, 4), Named("even"), OnError(tt.policy, &letters)))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if tt.err == nil && !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if l := letters.Letters(); !slices.Equal(inputs(l), tt.letters) {
+				t.Errorf("got letters %v, want inputs %v", l, tt.letters)
+			}
+			for _, l := range letters.Letters() {
+				if l.Stage != "even" || !errors.Is(l.Err, errOdd) {
+					t.Errorf("got letter %+v, want stage even failing with %v", l, errOdd)
+				}
+			}
+		})
+	}
+}
+
+func TestDeadLetterDefaultName(t *testing.T) {
+	var letters DeadLetters
+	f := From(upTo(2)).Then(failOn(), OnError(DeadLetter, &letters)).Then(failOn(1), OnError(DeadLetter, &letters))
+	if _, err := run(t, f); err != nil {
+		t.Fatal(err)
+	}
+	if l := letters.Letters(); len(l) != 1 || l[0].Stage != "stage 2" {
+		t.Errorf("got %+v, want a single letter from stage 2", l)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	noLeaks(t)
+	var letters DeadLetters
+	letters.add(Letter{Stage: "other", Input: 100})
+	letters.add(Letter{Stage: "parse", Input: "not an int"})
+	if _, err := run(t, From(upTo(6)).Then(failOn(1, 3, 5), Named("parse"), OnError(DeadLetter, &letters))); err != nil {
+		t.Fatal(err)
+	}
+
+	// 3 fails again and goes back, the rest are let through.
+	got, err := run(t, From(Replay[int](&letters, "parse")).Then(failOn(3), Named("parse"), OnError(DeadLetter, &letters)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 5}; !slices.Equal(got, want) {
+		t.Errorf("replayed %v, want %v", got, want)
+	}
+	var parse []Letter
+	for _, l := range letters.Letters() {
+		if l.Stage == "parse" {
+			parse = append(parse, l)
+		}
+	}
+	if len(parse) != 2 || parse[0].Input != "not an int" || parse[1].Input != 3 {
+		t.Errorf("got parse letters %+v, want the string left and 3 put back", parse)
+	}
+	if l := letters.Letters(); l[0].Stage != "other" {
+		t.Errorf("the other stage's letter went missing: %+v", l)
+	}
+}
+
+func TestReplayCancelledKeepsTheRest(t *testing.T) {
+	noLeaks(t)
+	var letters DeadLetters
+	for i := range 5 {
+		letters.add(Letter{Stage: "s", Input: i})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var got []int
+	err := From(Replay[int](&letters, "s")).Sink(ctx, func(_ context.Context, v int) error {
+		got = append(got, v)
+		if len(got) == 2 {
+			cancel()
+		}
+		return nil
+	}).Wait()
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+
+	left := inputs(letters.Letters())
+	if len(left) == 0 {
+		t.Fatal("every unreplayed letter was lost")
+	}
+	if all := append(slices.Clone(got), left...); !slices.Equal(all, []int{0, 1, 2, 3, 4}) {
+		t.Errorf("replayed %v and kept %v, want the two to make up 0 to 4 in order", got, left)
+	}
+}
+
+func TestReplayCancelledKeepsInFlight(t *testing.T) {
+	configs := []struct {
+		name string
+		opts []StageOption
+	}{
+		{"single", nil},
+		{"unordered", []StageOption{Workers(4)}},
+		{"ordered", []StageOption{Workers(4), Ordered()}},
+	}
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			noLeaks(t)
+			var letters DeadLetters
+			for i := range 20 {
+				letters.add(Letter{Stage: "s", Input: i})
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var got []int
+			opts := append([]StageOption{Named("s"), OnError(DeadLetter, &letters)}, c.opts...)
+			err := From(Replay[int](&letters, "s")).Then(failOn(), opts...).Sink(ctx, func(_ context.Context, v int) error {
+				got = append(got, v)
+				if len(got) == 2 {
+					cancel()
+				}
+				return nil
+			}).Wait()
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("got %v, want %v", err, context.Canceled)
+			}
+
+			// values the stage had taken in when the flow stopped are
+			// back alongside those never emitted.
+			all := append(slices.Clone(got), inputs(letters.Letters())...)
+			slices.Sort(all)
+			for i, v := range all {
+				if len(all) != 20 || v != i {
+					t.Fatalf("replayed %v and kept %v, want the two to make up 0 to 19 once each", got, inputs(letters.Letters()))
+				}
+			}
+		})
+	}
+}
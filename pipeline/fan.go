@@ -0,0 +1,156 @@
+package pipeline
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Merge fans the inbound channels into one, closing it once every one
+// of them is exhausted.  Values from the same inbound channel keep
+// their order, there is no order between channels.
+//
+// Each inbound channel gets a goroutine of its own which returns as
+// soon as its channel is closed, or done is closed while it waits to
+// either receive or send, so neither a closed nor a stalled inbound
+// channel can keep the others, or out, from finishing.
+func Merge[T any](done <-chan struct{}, inbound ...<-chan T) <-chan T {
+	out := make(chan T)
+	var wg sync.WaitGroup
+	wg.Add(len(inbound))
+	for _, c := range inbound {
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case v, ok := <-c:
+					if !ok {
+						return
+					}
+					select {
+					case out <- v:
+					case <-done:
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// MergeSelect is Merge in a single goroutine, which waits on every
+// inbound channel at once with reflect.Select.  It trades Merge's
+// goroutine per channel for the cost of reflection on every value, and
+// of a select whose work grows with the number of channels, so Merge
+// is the better choice unless goroutines are scarcer than CPU time.
+// The guarantees are the same as Merge's.
+func MergeSelect[T any](done <-chan struct{}, inbound ...<-chan T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		cases := make([]reflect.SelectCase, 0, len(inbound)+1)
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)})
+		for _, c := range inbound {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c)})
+		}
+
+		for open := len(inbound); open > 0; {
+			i, rv, ok := reflect.Select(cases)
+			switch {
+			case i == 0:
+				return
+			case !ok:
+				// a case with a zero Chan is ignored from then on.
+				cases[i].Chan = reflect.Value{}
+				open--
+				continue
+			}
+			// the comma ok form leaves v zero for a nil interface value.
+			v, _ := rv.Interface().(T)
+			select {
+			case out <- v:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// FanIn is Merge, by the name the pattern goes by.
+func FanIn[T any](done <-chan struct{}, inbound ...<-chan T) <-chan T {
+	return Merge(done, inbound...)
+}
+
+// FanOut spreads upstream across n downstream channels, each value
+// going to just one of them, whichever is free to take it.  It is how
+// work is shared between n copies of the next stage:
+//
+//	var sums []<-chan int
+//	for _, c := range pipeline.FanOut(done, jobs, runtime.NumCPU()) {
+//		sums = append(sums, pipeline.Map(done, c, slow))
+//	}
+//	results := pipeline.Merge(done, sums...)
+//
+// n < 1 is treated as 1.
+func FanOut[T any](done <-chan struct{}, upstream <-chan T, n int) []<-chan T {
+	outs := make([]<-chan T, max(n, 1))
+	for i := range outs {
+		outs[i] = OrDone(done, upstream)
+	}
+	return outs
+}
+
+// Tee sends every upstream value to both of its downstream channels.
+// Each value is delivered to both before the next is read, so the
+// slower reader sets the pace for the two.
+func Tee[T any](done <-chan struct{}, upstream <-chan T) (<-chan T, <-chan T) {
+	out1 := make(chan T)
+	out2 := make(chan T)
+	go func() {
+		defer close(out1)
+		defer close(out2)
+		for v := range OrDone(done, upstream) {
+			// nil out a channel once it has the value, so the select
+			// waits only on the one still to be sent to.
+			o1, o2 := out1, out2
+			for range 2 {
+				select {
+				case o1 <- v:
+					o1 = nil
+				case o2 <- v:
+					o2 = nil
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+	return out1, out2
+}
+
+// Bridge flattens a channel of channels into a single channel, reading
+// each inbound channel to exhaustion, in the order they arrive, before
+// moving on to the next.
+func Bridge[T any](done <-chan struct{}, chans <-chan <-chan T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for c := range OrDone(done, chans) {
+			for v := range OrDone(done, c) {
+				select {
+				case out <- v:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,283 @@
+package pipeline
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name    string
+		inbound [][]int
+	}{
+		{"no channels", nil},
+		{"one", [][]int{{1, 2, 3}}},
+		{"several", [][]int{{1, 2}, {3}, {4, 5, 6}}},
+		{"some empty", [][]int{{}, {1}, {}}},
+	}
+	merges := []struct {
+		name  string
+		merge func(done <-chan struct{}, inbound ...<-chan int) <-chan int
+	}{
+		{"Merge", Merge[int]},
+		{"FanIn", FanIn[int]},
+		{"MergeSelect", MergeSelect[int]},
+	}
+	for _, m := range merges {
+		for _, tt := range tests {
+			t.Run(m.name+"/"+tt.name, func(t *testing.T) {
+				noLeaks(t)
+				done := make(chan struct{})
+				defer close(done)
+				var inbound []<-chan int
+				var want []int
+				for _, values := range tt.inbound {
+					inbound = append(inbound, Generator(done, values...))
+					want = append(want, values...)
+				}
+				got := collect(t, m.merge(done, inbound...))
+				slices.Sort(got)
+				slices.Sort(want)
+				if !slices.Equal(got, want) {
+					t.Errorf("got %v, want %v", got, want)
+				}
+			})
+		}
+
+		t.Run(m.name+"/cancelled", func(t *testing.T) {
+			noLeaks(t)
+			done := make(chan struct{})
+			out := m.merge(done, Repeat(done, 1), stalled[int]())
+			<-out
+			close(done)
+			closes(t, out)
+		})
+	}
+}
+
+func TestMergeDoesNotLeak(t *testing.T) {
+	closed := func() <-chan int {
+		c := make(chan int)
+		close(c)
+		return c
+	}
+	tests := []struct {
+		name    string
+		inbound func(done <-chan struct{}) []<-chan int
+		read    int // values read before done is closed
+	}{
+		{"closed input", func(done <-chan struct{}) []<-chan int {
+			return []<-chan int{closed(), Repeat(done, 1)}
+		}, 1},
+		{"only closed inputs", func(done <-chan struct{}) []<-chan int {
+			return []<-chan int{closed(), closed()}
+		}, 0},
+		{"stalled input", func(done <-chan struct{}) []<-chan int {
+			return []<-chan int{stalled[int](), Generator(done, 1, 2)}
+		}, 2},
+		{"blocked sending", func(done <-chan struct{}) []<-chan int {
+			return []<-chan int{Repeat(done, 1), Repeat(done, 2)}
+		}, 0},
+		{"blocked sending after a read", func(done <-chan struct{}) []<-chan int {
+			return []<-chan int{Repeat(done, 1), stalled[int]()}
+		}, 3},
+	}
+	merges := []struct {
+		name  string
+		merge func(done <-chan struct{}, inbound ...<-chan int) <-chan int
+	}{
+		{"Merge", Merge[int]},
+		{"MergeSelect", MergeSelect[int]},
+	}
+	for _, m := range merges {
+		for _, tt := range tests {
+			t.Run(m.name+"/"+tt.name, func(t *testing.T) {
+				noLeaks(t)
+				done := make(chan struct{})
+				out := m.merge(done, tt.inbound(done)...)
+				for range tt.read {
+					<-out
+				}
+				close(done)
+				closes(t, out)
+			})
+		}
+	}
+}
+
+func TestMergeKeepsChannelOrder(t *testing.T) {
+	noLeaks(t)
+	done := make(chan struct{})
+	defer close(done)
+	a := Generator(done, 1, 2, 3, 4, 5)
+	b := Generator(done, 10, 20, 30, 40, 50)
+	var fromA, fromB []int
+	for _, v := range collect(t, Merge(done, a, b)) {
+		if v < 10 {
+			fromA = append(fromA, v)
+		} else {
+			fromB = append(fromB, v)
+		}
+	}
+	if !slices.IsSorted(fromA) || !slices.IsSorted(fromB) {
+		t.Errorf("values out of order within a channel: %v, %v", fromA, fromB)
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream []int
+		n        int
+	}{
+		{"one", []int{1, 2, 3}, 1},
+		{"several", []int{1, 2, 3, 4, 5, 6}, 3},
+		{"more than values", []int{1}, 4},
+		{"n below one", []int{1, 2}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			noLeaks(t)
+			done := make(chan struct{})
+			defer close(done)
+			outs := FanOut(done, Generator(done, tt.upstream...), tt.n)
+			if want := max(tt.n, 1); len(outs) != want {
+				t.Fatalf("got %d channels, want %d", len(outs), want)
+			}
+			got := collect(t, Merge(done, outs...))
+			slices.Sort(got)
+			if !slices.Equal(got, tt.upstream) {
+				t.Errorf("got %v, want each of %v once", got, tt.upstream)
+			}
+		})
+	}
+
+	t.Run("cancelled", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		outs := FanOut(done, Repeat(done, 1), 3)
+		<-outs[0]
+		close(done)
+		for _, out := range outs {
+			closes(t, out)
+		}
+	})
+}
+
+func TestTee(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream []int
+	}{
+		{"empty", nil},
+		{"values", []int{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			noLeaks(t)
+			done := make(chan struct{})
+			defer close(done)
+			out1, out2 := Tee(done, Generator(done, tt.upstream...))
+			var got1, got2 []int
+			for out1 != nil || out2 != nil {
+				select {
+				case v, ok := <-out1:
+					if !ok {
+						out1 = nil
+						continue
+					}
+					got1 = append(got1, v)
+				case v, ok := <-out2:
+					if !ok {
+						out2 = nil
+						continue
+					}
+					got2 = append(got2, v)
+				}
+			}
+			if !slices.Equal(got1, tt.upstream) || !slices.Equal(got2, tt.upstream) {
+				t.Errorf("got %v and %v, want %v on both", got1, got2, tt.upstream)
+			}
+		})
+	}
+
+	t.Run("cancelled with one reader", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		out1, out2 := Tee(done, Repeat(done, 1))
+		<-out1
+		close(done)
+		closes(t, out1)
+		closes(t, out2)
+	})
+}
+
+func TestBridge(t *testing.T) {
+	tests := []struct {
+		name  string
+		chans [][]int
+		want  []int
+	}{
+		{"no channels", nil, nil},
+		{"in turn", [][]int{{1, 2}, {3}, {4, 5}}, []int{1, 2, 3, 4, 5}},
+		{"empty channels", [][]int{{}, {1}, {}}, []int{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			noLeaks(t)
+			done := make(chan struct{})
+			defer close(done)
+			chans := make(chan (<-chan int))
+			go func() {
+				defer close(chans)
+				for _, values := range tt.chans {
+					chans <- Generator(done, values...)
+				}
+			}()
+			if got := collect(t, Bridge(done, chans)); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("cancelled", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		chans := make(chan (<-chan int), 1)
+		chans <- Repeat(done, 1)
+		out := Bridge(done, chans)
+		<-out
+		close(done)
+		closes(t, out)
+	})
+}
+
+// BenchmarkMerge compares a goroutine per inbound channel against a
+// single reflect.Select over all of them, as the channels multiply.
+func BenchmarkMerge(b *testing.B) {
+	merges := []struct {
+		name  string
+		merge func(done <-chan struct{}, inbound ...<-chan int) <-chan int
+	}{
+		{"Merge", Merge[int]},
+		{"MergeSelect", MergeSelect[int]},
+	}
+	for _, n := range []int{2, 16, 128, 1024} {
+		for _, m := range merges {
+			b.Run(fmt.Sprintf("%s/inputs=%d", m.name, n), func(b *testing.B) {
+				done := make(chan struct{})
+				defer close(done)
+				inbound := make([]<-chan int, n)
+				for i := range inbound {
+					inbound[i] = Repeat(done, i)
+				}
+				out := m.merge(done, inbound...)
+				b.ResetTimer()
+				for range b.N {
+					<-out
+				}
+			})
+		}
+	}
+}
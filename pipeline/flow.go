@@ -0,0 +1,429 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// Source produces the values a Flow starts from, handing each to emit
+// and returning once it has no more.  emit fails once the flow has been
+// cancelled, the source should then stop and return that error.
+type Source[T any] func(ctx context.Context, emit func(T) error) error
+
+// Stage turns each In of a Flow into an Out.  An error stops the whole
+// flow and is what its Wait returns, unless the stage was given another
+// ErrorPolicy with OnError.
+type Stage[In, Out any] func(ctx context.Context, v In) (Out, error)
+
+// StageOption configures a single stage of a Flow.
+type StageOption func(*stageConfig)
+
+// stageConfig holds the tunables of a single stage.
+type stageConfig struct {
+	workers int
+	ordered bool
+
+	buffer   int // 0 leaves the stage's output unbuffered
+	overflow OverflowPolicy
+	drops    *Drops
+
+	name    string
+	onError ErrorPolicy
+	letters *DeadLetters
+
+	recover     bool
+	panics      PanicPolicy
+	maxRestarts int
+}
+
+// Workers runs a stage in n goroutines, n < 1 is treated as 1.  With
+// more than one the stage's output is no longer in the order of its
+// input, each value is sent on as soon as it is ready, unless the stage
+// is also Ordered.
+func Workers(n int) StageOption {
+	return func(c *stageConfig) {
+		c.workers = max(n, 1)
+	}
+}
+
+// Ordered keeps the output of a stage run by several Workers in the
+// order of its input.  Each value is numbered on the way in and held
+// in a reorder buffer on the way out until every value before it has
+// been sent, so one slow value holds up those behind it.  The workers
+// can get at most twice their number of values ahead of the oldest
+// one still in progress, which bounds the buffer.  It has no effect on
+// a Sink, whose function sees values as they arrive.
+func Ordered() StageOption {
+	return func(c *stageConfig) {
+		c.ordered = true
+	}
+}
+
+// Buffered puts a Buffer of size values after a stage, so a slower
+// stage downstream of it is absorbed, or shed, according to policy
+// instead of holding it up.  Discarded values are counted in drops,
+// which may be nil.
+func Buffered(size int, policy OverflowPolicy, drops *Drops) StageOption {
+	return func(c *stageConfig) {
+		c.buffer = max(size, 1)
+		c.overflow = policy
+		c.drops = drops
+	}
+}
+
+// Named names a stage, for the dead letters it records.
+func Named(name string) StageOption {
+	return func(c *stageConfig) {
+		c.name = name
+	}
+}
+
+// OnError sets what a stage does with an input it fails on, stopping
+// the flow unless told otherwise.  letters is only used by the
+// DeadLetter policy, which without it behaves as Skip.
+func OnError(policy ErrorPolicy, letters *DeadLetters) StageOption {
+	return func(c *stageConfig) {
+		c.onError = policy
+		c.letters = letters
+	}
+}
+
+// failed applies the stage's error policy to err, returned for input
+// v, returning err again if the flow has to stop.  A stage failing
+// because the flow was cancelled always stops, with v kept as a dead
+// letter if the stage keeps them, so it is not lost.  Recovered panics are
+// subject to the PanicPolicy instead, a *restartError is returned for
+// the worker to be replaced.
+func (c stageConfig) failed(ctx context.Context, v any, err error) error {
+	if ctx.Err() != nil {
+		c.deadLetter(v, err)
+		return err
+	}
+	if pe, ok := err.(*PanicError); ok && c.recover {
+		switch c.panics {
+		case AbortOnPanic:
+			return err
+		case RestartOnPanic:
+			c.deadLetter(v, err)
+			return &restartError{pe}
+		}
+		c.deadLetter(v, err)
+		return nil
+	}
+	switch c.onError {
+	case Skip:
+		return nil
+	case DeadLetter:
+		c.deadLetter(v, err)
+		return nil
+	}
+	return err
+}
+
+// deadLetter records v as a dead letter if the stage keeps them.
+func (c stageConfig) deadLetter(v any, err error) {
+	if c.onError == DeadLetter && c.letters != nil {
+		c.letters.add(Letter{Stage: c.name, Input: v, Err: err})
+	}
+}
+
+// newStageConfig returns the defaults with opts applied.
+func newStageConfig(opts ...StageOption) stageConfig {
+	cfg := stageConfig{workers: 1, maxRestarts: 3}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// Flow is a pipeline under construction whose last stage produces T.
+// Nothing runs until Sink is called, so a Flow can be built up in one
+// place and run in another:
+//
+//	h := pipeline.From(lines).
+//		Then(trim).
+//		Then(lookup, pipeline.Workers(8), pipeline.Ordered()).
+//		Sink(ctx, print)
+//	err := h.Wait()
+//
+// Then on a Flow keeps its type, the Then function changes it, which is
+// how bytes become records and records become aggregates:
+//
+//	records := pipeline.Then(pipeline.From(chunks), parse)
+//	totals := pipeline.Then(records, aggregate)
+//
+// Either way the stages must fit together or the code does not compile.
+type Flow[T any] struct {
+	start  func(r *runner) <-chan T
+	stages int // stages so far, to number the next one
+}
+
+// From starts a Flow with the values src produces.
+func From[T any](src Source[T]) *Flow[T] {
+	return &Flow[T]{start: func(r *runner) <-chan T {
+		out := make(chan T)
+		r.spawn(func() error {
+			defer close(out)
+			return src(r.ctx, func(v T) error {
+				return send(r.ctx, out, v)
+			})
+		})
+		return out
+	}}
+}
+
+// Then appends a stage of the same type to the Flow.
+func (f *Flow[T]) Then(s Stage[T, T], opts ...StageOption) *Flow[T] {
+	return Then(f, s, opts...)
+}
+
+// Then appends a stage to f, the resulting Flow produces what s does.
+func Then[In, Out any](f *Flow[In], s Stage[In, Out], opts ...StageOption) *Flow[Out] {
+	cfg := newStageConfig(append([]StageOption{Named(fmt.Sprintf("stage %d", f.stages+1))}, opts...)...)
+	return &Flow[Out]{stages: f.stages + 1, start: func(r *runner) <-chan Out {
+		in := f.start(r)
+		var out <-chan Out
+		if cfg.ordered && cfg.workers > 1 {
+			out = orderedStage(r, in, s, cfg)
+		} else {
+			out = unorderedStage(r, in, s, cfg)
+		}
+		if cfg.buffer > 0 {
+			out = Buffer(r.ctx.Done(), out, cfg.buffer, cfg.overflow, cfg.drops)
+		}
+		return out
+	}}
+}
+
+// unorderedStage runs s over in with workers goroutines, each sending
+// its results on as soon as they are ready.
+func unorderedStage[In, Out any](r *runner, in <-chan In, s Stage[In, Out], cfg stageConfig) <-chan Out {
+	out := make(chan Out)
+	var wg sync.WaitGroup
+	supervise(r, cfg, &wg, func() error {
+		for {
+			v, ok, err := recv(r.ctx, in)
+			if !ok {
+				return err
+			}
+			res, err := call(r.ctx, cfg, s, v)
+			if err != nil {
+				if err := cfg.failed(r.ctx, v, err); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := send(r.ctx, out, res); err != nil {
+				cfg.deadLetter(v, err)
+				return err
+			}
+		}
+	})
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// sequenced is a value tagged with its position in the stream.
+type sequenced[T any] struct {
+	seq     uint64
+	v       T
+	skipped bool // the stage failed on it and carried on, v is not set
+	input   any  // what v was made from, a dead letter if v is never sent
+}
+
+// orderedStage runs s over in with workers goroutines, restoring the
+// order of in before sending the results on, see Ordered.
+func orderedStage[In, Out any](r *runner, in <-chan In, s Stage[In, Out], cfg stageConfig) <-chan Out {
+	// a slot is taken as a value is numbered and given back once its
+	// result has been sent on, so window caps the values in between.
+	window := make(chan struct{}, 2*cfg.workers)
+	numbered := make(chan sequenced[In])
+	r.spawn(func() error {
+		defer close(numbered)
+		for seq := uint64(0); ; seq++ {
+			v, ok, err := recv(r.ctx, in)
+			if !ok {
+				return err
+			}
+			if err := send(r.ctx, window, struct{}{}); err != nil {
+				cfg.deadLetter(v, err)
+				return err
+			}
+			if err := send(r.ctx, numbered, sequenced[In]{seq: seq, v: v}); err != nil {
+				cfg.deadLetter(v, err)
+				return err
+			}
+		}
+	})
+
+	results := make(chan sequenced[Out])
+	var wg sync.WaitGroup
+	supervise(r, cfg, &wg, func() error {
+		for {
+			n, ok, err := recv(r.ctx, numbered)
+			if !ok {
+				return err
+			}
+			// a skipped value still has to be accounted for, or the
+			// values after it would wait on it forever, even when its
+			// worker is about to be restarted.
+			res := sequenced[Out]{seq: n.seq, input: n.v}
+			var failure error
+			if res.v, err = call(r.ctx, cfg, s, n.v); err != nil {
+				failure = cfg.failed(r.ctx, n.v, err)
+				if _, restart := failure.(*restartError); failure != nil && !restart {
+					return failure
+				}
+				res.skipped = true
+			}
+			if err := send(r.ctx, results, res); err != nil {
+				if !res.skipped {
+					cfg.deadLetter(n.v, err)
+				}
+				return err
+			}
+			if failure != nil {
+				return failure
+			}
+		}
+	})
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	out := make(chan Out)
+	r.spawn(func() error {
+		defer close(out)
+		pending := make(map[uint64]sequenced[Out], cap(window))
+		defer func() {
+			// results still waiting on an earlier one when the flow
+			// stops are kept as dead letters, in order.
+			for _, seq := range slices.Sorted(maps.Keys(pending)) {
+				if res := pending[seq]; !res.skipped {
+					cfg.deadLetter(res.input, r.ctx.Err())
+				}
+			}
+		}()
+		var next uint64
+		for {
+			res, ok, err := recv(r.ctx, results)
+			if !ok {
+				return err
+			}
+			pending[res.seq] = res
+			for res, ok := pending[next]; ok; res, ok = pending[next] {
+				if !res.skipped {
+					if err := send(r.ctx, out, res.v); err != nil {
+						return err
+					}
+				}
+				delete(pending, next)
+				<-window
+				next++
+			}
+		}
+	})
+	return out
+}
+
+// Sink runs the Flow, handing every value it produces to fn.  The flow
+// stops at the first error from any stage, the source or fn, that its
+// ErrorPolicy does not deal with, or when ctx is cancelled, and the
+// returned Handle reports why.
+func (f *Flow[T]) Sink(ctx context.Context, fn func(ctx context.Context, v T) error, opts ...StageOption) *Handle {
+	cfg := newStageConfig(append([]StageOption{Named("sink")}, opts...)...)
+	r := newRunner(ctx)
+	in := f.start(r)
+	var wg sync.WaitGroup
+	supervise(r, cfg, &wg, func() error {
+		for {
+			v, ok, err := recv(r.ctx, in)
+			if !ok {
+				return err
+			}
+			_, err = call(r.ctx, cfg, func(ctx context.Context, v T) (struct{}, error) {
+				return struct{}{}, fn(ctx, v)
+			}, v)
+			if err != nil {
+				if err := cfg.failed(r.ctx, v, err); err != nil {
+					return err
+				}
+			}
+		}
+	})
+	return &Handle{r: r}
+}
+
+// Handle is a running Flow.
+type Handle struct {
+	r *runner
+}
+
+// Wait blocks until every goroutine of the flow has returned, then
+// reports the first error that stopped it, ctx.Err() if it was
+// cancelled, or nil if it ran to completion.
+func (h *Handle) Wait() error {
+	h.r.wg.Wait()
+	h.r.cancel()
+	return h.r.err
+}
+
+// runner tracks the goroutines of a running Flow and cancels them all
+// as soon as one of them fails.
+type runner struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	once sync.Once
+	err  error
+}
+
+// newRunner returns a runner whose goroutines stop when ctx does.
+func newRunner(ctx context.Context) *runner {
+	ctx, cancel := context.WithCancel(ctx)
+	return &runner{ctx: ctx, cancel: cancel}
+}
+
+// spawn runs fn in a goroutine, the first error returned by any of the
+// runner's goroutines is kept and cancels the rest.
+func (r *runner) spawn(fn func() error) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		if err := fn(); err != nil {
+			r.once.Do(func() {
+				r.err = err
+				r.cancel()
+			})
+		}
+	}()
+}
+
+// send delivers v on out unless ctx is cancelled first.
+func send[T any](ctx context.Context, out chan<- T, v T) error {
+	select {
+	case out <- v:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// recv takes the next value from in.  ok is false once in is closed,
+// with a nil error, or ctx is cancelled, with its error.
+func recv[T any](ctx context.Context, in <-chan T) (v T, ok bool, err error) {
+	select {
+	case v, ok := <-in:
+		return v, ok, nil
+	case <-ctx.Done():
+		return v, false, ctx.Err()
+	}
+}
@@ -0,0 +1,227 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+)
+
+// upTo is a Source of the integers 0 to n-1.
+func upTo(n int) Source[int] {
+	return func(_ context.Context, emit func(int) error) error {
+		for i := range n {
+			if err := emit(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// run sinks f, returning what arrived and the error Wait reports.
+func run[T any](t *testing.T, f *Flow[T]) ([]T, error) {
+	t.Helper()
+	var got []T
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := f.Sink(ctx, func(_ context.Context, v T) error {
+		got = append(got, v)
+		return nil
+	}).Wait()
+	if ctx.Err() != nil {
+		t.Fatal("flow did not finish in time")
+	}
+	return got, err
+}
+
+var errOdd = errors.New("odd")
+
+func TestOrderedKeepsOrder(t *testing.T) {
+	const n = 300
+	// jitter makes later inputs overtake earlier ones in the workers.
+	jitter := func() { time.Sleep(time.Duration(rand.N(50)) * time.Microsecond) }
+	tests := []struct {
+		name  string
+		stage Stage[int, int]
+		opts  []StageOption
+		drop  func(int) bool // inputs that do not come out
+	}{
+		{
+			name: "no failures",
+			stage: func(_ context.Context, v int) (int, error) {
+				jitter()
+				return v, nil
+			},
+			drop: func(int) bool { return false },
+		},
+		{
+			name: "skipped failures",
+			stage: func(_ context.Context, v int) (int, error) {
+				jitter()
+				if v%7 == 0 {
+					return 0, errOdd
+				}
+				return v, nil
+			},
+			opts: []StageOption{OnError(Skip, nil)},
+			drop: func(v int) bool { return v%7 == 0 },
+		},
+		{
+			name: "dead letters",
+			stage: func(_ context.Context, v int) (int, error) {
+				jitter()
+				if v%5 == 0 {
+					return 0, errOdd
+				}
+				return v, nil
+			},
+			opts: []StageOption{OnError(DeadLetter, &DeadLetters{})},
+			drop: func(v int) bool { return v%5 == 0 },
+		},
+		{
+			name: "restarted workers",
+			stage: func(_ context.Context, v int) (int, error) {
+				jitter()
+				if v%11 == 0 {
+					panic(v)
+				}
+				return v, nil
+			},
+			opts: []StageOption{RecoverPanics(RestartOnPanic), MaxRestarts(n)},
+			drop: func(v int) bool { return v%11 == 0 },
+		},
+		{
+			name: "skipped panics",
+			stage: func(_ context.Context, v int) (int, error) {
+				jitter()
+				if v%13 == 0 {
+					panic(v)
+				}
+				return v, nil
+			},
+			opts: []StageOption{RecoverPanics(SkipOnPanic)},
+			drop: func(v int) bool { return v%13 == 0 },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			noLeaks(t)
+			opts := append([]StageOption{Workers(4), Ordered()}, tt.opts...)
+			got, err := run(t, From(upTo(n)).Then(tt.stage, opts...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want []int
+			for i := range n {
+				if !tt.drop(i) {
+					want = append(want, i)
+				}
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("got %v\nwant %v", got, want)
+			}
+		})
+	}
+}
+
+func TestUnorderedDeliversEverything(t *testing.T) {
+	noLeaks(t)
+	got, err := run(t, From(upTo(500)).Then(func(_ context.Context, v int) (int, error) {
+		return v, nil
+	}, Workers(8)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(got)
+	if len(got) != 500 || got[0] != 0 || got[499] != 499 {
+		t.Errorf("got %d values, want 0 to 499 once each", len(got))
+	}
+}
+
+func TestFlowStopsAtFirstError(t *testing.T) {
+	configs := []struct {
+		name string
+		opts []StageOption
+	}{
+		{"single", nil},
+		{"unordered", []StageOption{Workers(4)}},
+		{"ordered", []StageOption{Workers(4), Ordered()}},
+	}
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			noLeaks(t)
+			// the source is endless, only the error can stop it.
+			endless := func(ctx context.Context, emit func(int) error) error {
+				for i := 0; ; i++ {
+					if err := emit(i); err != nil {
+						return err
+					}
+				}
+			}
+			_, err := run(t, From(endless).Then(func(_ context.Context, v int) (int, error) {
+				if v == 100 {
+					return 0, errOdd
+				}
+				return v, nil
+			}, c.opts...))
+			if !errors.Is(err, errOdd) {
+				t.Errorf("got %v, want %v", err, errOdd)
+			}
+		})
+	}
+}
+
+func TestFlowCancelled(t *testing.T) {
+	noLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	endless := func(ctx context.Context, emit func(int) error) error {
+		for i := 0; ; i++ {
+			if err := emit(i); err != nil {
+				return err
+			}
+		}
+	}
+	h := From(endless).Then(func(_ context.Context, v int) (int, error) {
+		return v, nil
+	}, Workers(4), Ordered()).Sink(ctx, func(_ context.Context, v int) error {
+		if v == 10 {
+			cancel()
+		}
+		return nil
+	})
+	if err := h.Wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+// BenchmarkFlow runs the 1,000,000 values of the basic pipeline's
+// generator through a cheap stage, the cost is then mostly that of
+// moving values between goroutines and, when Ordered, back in order.
+func BenchmarkFlow(b *testing.B) {
+	double := func(_ context.Context, v int) (int, error) { return 2 * v, nil }
+	workers := runtime.GOMAXPROCS(0)
+	benchmarks := []struct {
+		name string
+		opts []StageOption
+	}{
+		{"single", nil},
+		{"unordered", []StageOption{Workers(workers)}},
+		{"ordered", []StageOption{Workers(workers), Ordered()}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			for range b.N {
+				err := From(upTo(1_000_000)).Then(double, bm.opts...).Sink(context.Background(), func(context.Context, int) error {
+					return nil
+				}).Wait()
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
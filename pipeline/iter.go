@@ -0,0 +1,109 @@
+package pipeline
+
+import (
+	"context"
+	"iter"
+)
+
+// Pair is a key and value from an iter.Seq2 travelling down a channel.
+type Pair[K, V any] struct {
+	Key   K
+	Value V
+}
+
+// Seq ranges over upstream until it is exhausted or done is closed.
+// Breaking out of the loop stops the reading but not whatever sends on
+// upstream, that is what done is for, or see Iterate:
+//
+//	for v := range pipeline.Seq(done, c) { ... }
+func Seq[T any](done <-chan struct{}, upstream <-chan T) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for {
+			select {
+			case v, ok := <-upstream:
+				if !ok || !yield(v) {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}
+}
+
+// Seq2 is Seq for a channel of Pairs.
+func Seq2[K, V any](done <-chan struct{}, upstream <-chan Pair[K, V]) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for p := range Seq(done, upstream) {
+			if !yield(p.Key, p.Value) {
+				return
+			}
+		}
+	}
+}
+
+// Iterate ranges over the channel stage returns, stage being handed a
+// done channel of its own which is closed once the loop is over, broken
+// out of or not.  Any goroutine stage started that honours done is then
+// stopped rather than left blocked on a send nobody will receive:
+//
+//	for v := range pipeline.Iterate(func(done <-chan struct{}) <-chan int {
+//		return pipeline.Repeat(done, 1, 2, 3)
+//	}) {
+//		if v == 3 {
+//			break // Repeat returns
+//		}
+//	}
+func Iterate[T any](stage func(done <-chan struct{}) <-chan T) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		done := make(chan struct{})
+		defer close(done)
+		for v := range stage(done) {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// Chan sends the values of seq downstream, then closes.  Closing done
+// stops the iteration, seq sees its yield return false, so the
+// goroutine ranging over it returns too.
+func Chan[T any](done <-chan struct{}, seq iter.Seq[T]) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for v := range seq {
+			select {
+			case out <- v:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Chan2 is Chan for an iter.Seq2, each key and value sent as a Pair.
+func Chan2[K, V any](done <-chan struct{}, seq iter.Seq2[K, V]) <-chan Pair[K, V] {
+	return Chan(done, func(yield func(Pair[K, V]) bool) {
+		for k, v := range seq {
+			if !yield(Pair[K, V]{k, v}) {
+				return
+			}
+		}
+	})
+}
+
+// Each is a Source producing the values of seq, stopping early if the
+// flow is cancelled.
+func Each[T any](seq iter.Seq[T]) Source[T] {
+	return func(_ context.Context, emit func(T) error) error {
+		for v := range seq {
+			if err := emit(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
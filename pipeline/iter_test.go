@@ -0,0 +1,202 @@
+package pipeline
+
+import (
+	"iter"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+// producer is a channel stage sending 0, 1, 2 and so on until done is
+// closed, closing stopped once it has returned.
+func producer(stopped chan<- struct{}) func(done <-chan struct{}) <-chan int {
+	return func(done <-chan struct{}) <-chan int {
+		out := make(chan int)
+		go func() {
+			defer close(stopped)
+			defer close(out)
+			for i := 0; ; i++ {
+				select {
+				case out <- i:
+				case <-done:
+					return
+				}
+			}
+		}()
+		return out
+	}
+}
+
+// stops fails t unless stopped is closed in time.
+func stops(t *testing.T, stopped <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-stopped:
+	case <-time.After(wait):
+		t.Fatal("producer still running")
+	}
+}
+
+// naturals yields 0, 1, 2 and so on until told to stop.
+func naturals(yield func(int) bool) {
+	for i := 0; yield(i); i++ {
+	}
+}
+
+func TestSeq(t *testing.T) {
+	t.Run("exhausted", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		defer close(done)
+		if got := slices.Collect(Seq(done, Generator(done, 1, 2, 3))); !slices.Equal(got, []int{1, 2, 3}) {
+			t.Errorf("got %v, want [1 2 3]", got)
+		}
+	})
+
+	t.Run("break", func(t *testing.T) {
+		noLeaks(t)
+		stopped := make(chan struct{})
+		// the producer is stopped by the done closed on the way out.
+		func() {
+			done := make(chan struct{})
+			defer close(done)
+			for v := range Seq(done, producer(stopped)(done)) {
+				if v == 3 {
+					break
+				}
+			}
+		}()
+		stops(t, stopped)
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		close(done)
+		for v := range Seq(done, stalled[int]()) {
+			t.Fatalf("got %v from a stalled channel", v)
+		}
+	})
+}
+
+func TestSeq2(t *testing.T) {
+	noLeaks(t)
+	done := make(chan struct{})
+	defer close(done)
+	want := map[string]int{"a": 1, "b": 2, "c": 3}
+	if got := maps.Collect(Seq2(done, Chan2(done, maps.All(want)))); !maps.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for k := range Seq2(done, Chan2(done, maps.All(want))) {
+		if _, ok := want[k]; !ok {
+			t.Errorf("got key %q", k)
+		}
+		break
+	}
+}
+
+func TestIterate(t *testing.T) {
+	t.Run("break stops the producer", func(t *testing.T) {
+		noLeaks(t)
+		stopped := make(chan struct{})
+		var got []int
+		for v := range Iterate(producer(stopped)) {
+			if got = append(got, v); v == 3 {
+				break
+			}
+		}
+		stops(t, stopped)
+		if !slices.Equal(got, []int{0, 1, 2, 3}) {
+			t.Errorf("got %v, want [0 1 2 3]", got)
+		}
+	})
+
+	t.Run("pulled", func(t *testing.T) {
+		noLeaks(t)
+		stopped := make(chan struct{})
+		next, stop := iter.Pull(Iterate(producer(stopped)))
+		if v, ok := next(); !ok || v != 0 {
+			t.Fatalf("got %v, %v, want 0, true", v, ok)
+		}
+		stop()
+		stops(t, stopped)
+	})
+
+	t.Run("exhausted", func(t *testing.T) {
+		noLeaks(t)
+		got := slices.Collect(Iterate(func(done <-chan struct{}) <-chan int {
+			return Generator(done, 1, 2)
+		}))
+		if !slices.Equal(got, []int{1, 2}) {
+			t.Errorf("got %v, want [1 2]", got)
+		}
+	})
+}
+
+func TestChan(t *testing.T) {
+	t.Run("exhausted", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		defer close(done)
+		if got := collect(t, Chan(done, slices.Values([]int{1, 2, 3}))); !slices.Equal(got, []int{1, 2, 3}) {
+			t.Errorf("got %v, want [1 2 3]", got)
+		}
+	})
+
+	t.Run("cancelled stops the seq", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		stopped := make(chan struct{})
+		out := Chan(done, func(yield func(int) bool) {
+			defer close(stopped)
+			naturals(yield)
+		})
+		<-out
+		close(done)
+		closes(t, out)
+		stops(t, stopped)
+	})
+}
+
+// BenchmarkGenerators is the cost of a value from an endless generator
+// written either way: a goroutine sending on a channel, an iter.Seq
+// pulled with iter.Pull, or the two adapted to one another.
+func BenchmarkGenerators(b *testing.B) {
+	b.Run("channel", func(b *testing.B) {
+		done := make(chan struct{})
+		defer close(done)
+		c := Chan(done, naturals)
+		b.ResetTimer()
+		for range b.N {
+			<-c
+		}
+	})
+	b.Run("iter.Pull", func(b *testing.B) {
+		next, stop := iter.Pull(iter.Seq[int](naturals))
+		defer stop()
+		b.ResetTimer()
+		for range b.N {
+			next()
+		}
+	})
+	b.Run("iter.Pull of Seq", func(b *testing.B) {
+		done := make(chan struct{})
+		defer close(done)
+		next, stop := iter.Pull(Seq(done, Chan(done, naturals)))
+		defer stop()
+		b.ResetTimer()
+		for range b.N {
+			next()
+		}
+	})
+	b.Run("range", func(b *testing.B) {
+		n := 0
+		for range naturals {
+			if n++; n == b.N {
+				break
+			}
+		}
+	})
+}
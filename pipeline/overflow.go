@@ -0,0 +1,154 @@
+package pipeline
+
+import (
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+// OverflowPolicy decides what a Buffer does with a value that arrives
+// while it is full.
+type OverflowPolicy int
+
+const (
+	// Block stops reading upstream until there is room, the producer
+	// waits on the consumer exactly as with an unbuffered channel, just
+	// size values later.
+	Block OverflowPolicy = iota
+	// DropNewest discards the value that just arrived.
+	DropNewest
+	// DropOldest discards the value that has waited longest, the buffer
+	// becomes a ring holding the latest size values.
+	DropOldest
+	// Sample keeps a uniform random sample of the values that arrived
+	// while the buffer was full, by reservoir sampling, so a consumer
+	// that falls behind sees values from the whole of the burst rather
+	// than just its start or end.  Sampled values may leave out of order.
+	Sample
+)
+
+// String implements fmt.Stringer.
+func (p OverflowPolicy) String() string {
+	switch p {
+	case Block:
+		return "block"
+	case DropNewest:
+		return "drop-newest"
+	case DropOldest:
+		return "drop-oldest"
+	default:
+		return "sample"
+	}
+}
+
+// Drops counts the values a Buffer discarded.  It is safe for
+// concurrent use and its zero value is ready to use.
+type Drops struct {
+	n atomic.Int64
+}
+
+// Count is the number of values dropped so far.
+func (d *Drops) Count() int64 {
+	return d.n.Load()
+}
+
+// add counts one more drop, a nil *Drops counts nothing.
+func (d *Drops) add() {
+	if d != nil {
+		d.n.Add(1)
+	}
+}
+
+// Buffer holds up to size values between upstream and a consumer that
+// cannot keep up, handling overflow according to policy and counting
+// every value it discards in drops, which may be nil.  Under any policy
+// but Block the producer is never held up by the consumer, which is
+// how a stage can shed load instead of stalling everything upstream.
+// size < 1 is treated as 1.
+func Buffer[T any](done <-chan struct{}, upstream <-chan T, size int, policy OverflowPolicy, drops *Drops) <-chan T {
+	size = max(size, 1)
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		q := newRing[T](size)
+		overflow := 0 // values that arrived while full, for Sample
+		in := upstream
+		for in != nil || q.len() > 0 {
+			// a nil channel is never ready, which is how a select case
+			// is switched off.
+			var send chan<- T
+			var next T
+			if q.len() > 0 {
+				send, next = out, q.front()
+			}
+			recv := in
+			if policy == Block && q.len() == size {
+				recv = nil
+			}
+
+			select {
+			case v, ok := <-recv:
+				if !ok {
+					in = nil
+					continue
+				}
+				if q.len() < size {
+					q.push(v)
+					continue
+				}
+				drops.add()
+				switch policy {
+				case DropOldest:
+					q.pop()
+					q.push(v)
+				case Sample:
+					overflow++
+					if i := rand.IntN(size + overflow); i < size {
+						q.set(i, v)
+					}
+				}
+			case send <- next:
+				q.pop()
+				if q.len() < size {
+					overflow = 0
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// ring is a fixed capacity FIFO queue.
+type ring[T any] struct {
+	buf  []T
+	head int
+	n    int
+}
+
+// newRing returns an empty ring holding at most size values.
+func newRing[T any](size int) *ring[T] {
+	return &ring[T]{buf: make([]T, size)}
+}
+
+func (r *ring[T]) len() int { return r.n }
+
+// front is the oldest value, the ring must not be empty.
+func (r *ring[T]) front() T { return r.buf[r.head] }
+
+// set replaces the i'th oldest value.
+func (r *ring[T]) set(i int, v T) { r.buf[(r.head+i)%len(r.buf)] = v }
+
+// push appends v, the ring must not be full.
+func (r *ring[T]) push(v T) {
+	r.buf[(r.head+r.n)%len(r.buf)] = v
+	r.n++
+}
+
+// pop discards the oldest value, the ring must not be empty.
+func (r *ring[T]) pop() {
+	var zero T
+	r.buf[r.head] = zero
+	r.head = (r.head + 1) % len(r.buf)
+	r.n--
+}
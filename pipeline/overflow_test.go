@@ -0,0 +1,155 @@
+package pipeline
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// filled returns a closed channel holding values, ready to be read at
+// once in full.
+func filled[T any](values ...T) <-chan T {
+	c := make(chan T, len(values))
+	for _, v := range values {
+		c <- v
+	}
+	close(c)
+	return c
+}
+
+// overflowed buffers values with nobody reading until the buffer has
+// dropped want of them, then returns what it kept.
+func overflowed(t *testing.T, policy OverflowPolicy, size int, want int64, values ...int) []int {
+	t.Helper()
+	done := make(chan struct{})
+	defer close(done)
+	var drops Drops
+	out := Buffer(done, filled(values...), size, policy, &drops)
+	deadline := time.Now().Add(wait)
+	for drops.Count() < want {
+		if time.Now().After(deadline) {
+			t.Fatalf("%v dropped %d values, want %d", policy, drops.Count(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	got := collect(t, out)
+	if drops.Count() != want {
+		t.Errorf("%v dropped %d values, want %d", policy, drops.Count(), want)
+	}
+	return got
+}
+
+func TestBuffer(t *testing.T) {
+	values := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	tests := []struct {
+		policy OverflowPolicy
+		want   []int
+	}{
+		{DropNewest, []int{1, 2, 3}},
+		{DropOldest, []int{8, 9, 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			noLeaks(t)
+			if got := overflowed(t, tt.policy, 3, 7, values...); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("sample", func(t *testing.T) {
+		noLeaks(t)
+		got := overflowed(t, Sample, 3, 7, values...)
+		slices.Sort(got)
+		if len(got) != 3 || len(slices.Compact(slices.Clone(got))) != 3 {
+			t.Fatalf("got %v, want 3 distinct values", got)
+		}
+		for _, v := range got {
+			if !slices.Contains(values, v) {
+				t.Errorf("got %v, not one of %v", v, values)
+			}
+		}
+	})
+
+	t.Run("no overflow", func(t *testing.T) {
+		for _, policy := range []OverflowPolicy{Block, DropNewest, DropOldest, Sample} {
+			noLeaks(t)
+			done := make(chan struct{})
+			var drops Drops
+			got := collect(t, Buffer(done, filled(1, 2, 3), 5, policy, &drops))
+			close(done)
+			if !slices.Equal(got, []int{1, 2, 3}) || drops.Count() != 0 {
+				t.Errorf("%v: got %v with %d drops, want [1 2 3] with none", policy, got, drops.Count())
+			}
+		}
+	})
+
+	t.Run("nil drops", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		defer close(done)
+		out := Buffer(done, filled(1, 2, 3), 1, DropNewest, nil)
+		time.Sleep(10 * time.Millisecond)
+		if got := collect(t, out); len(got) != 1 {
+			t.Errorf("got %v, want a single value", got)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		for _, policy := range []OverflowPolicy{Block, DropNewest, DropOldest, Sample} {
+			noLeaks(t)
+			done := make(chan struct{})
+			out := Buffer(done, Repeat(done, 1), 2, policy, nil)
+			<-out
+			close(done)
+			closes(t, out)
+		}
+	})
+}
+
+func TestBufferBlocks(t *testing.T) {
+	noLeaks(t)
+	done := make(chan struct{})
+	defer close(done)
+	up := make(chan int)
+	var drops Drops
+	out := Buffer(done, up, 2, Block, &drops)
+	up <- 1
+	up <- 2
+	select {
+	case up <- 3:
+		t.Fatal("a full Block buffer took another value")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if v := <-out; v != 1 {
+		t.Fatalf("got %d, want 1", v)
+	}
+	select {
+	case up <- 3:
+	case <-time.After(wait):
+		t.Fatal("no room made by the read")
+	}
+	close(up)
+	if got := collect(t, out); !slices.Equal(got, []int{2, 3}) || drops.Count() != 0 {
+		t.Errorf("got %v with %d drops, want [2 3] with none", got, drops.Count())
+	}
+}
+
+// TestBufferSampleIsUniform checks every value of a burst is equally
+// likely to survive Sample, size in len(values) of the time.
+func TestBufferSampleIsUniform(t *testing.T) {
+	const trials, size = 1000, 3
+	values := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	kept := make([]int, len(values))
+	for range trials {
+		for _, v := range overflowed(t, Sample, size, int64(len(values)-size), values...) {
+			kept[v]++
+		}
+	}
+	want := float64(trials*size) / float64(len(values))
+	for v, n := range kept {
+		if float64(n) < 0.75*want || float64(n) > 1.25*want {
+			t.Errorf("value %d kept %d times in %d, want about %.0f", v, n, trials, want)
+		}
+	}
+}
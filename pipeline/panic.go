@@ -0,0 +1,240 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// PanicError is a panic recovered from a stage, along with the input
+// the stage panicked on and the stack of the goroutine at the time.
+type PanicError struct {
+	Stage string
+	Input any
+	Value any // as passed to panic
+	Stack []byte
+}
+
+// Error implements error, the stack trace included.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%s panicked on %v: %v\n\n%s", e.Stage, e.Input, e.Value, e.Stack)
+}
+
+// Unwrap returns the value the stage panicked with, if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// Protect calls fn with v, turning a panic into a *PanicError naming
+// stage and carrying v, so that one bad input costs an error rather
+// than the process.
+func Protect[In, Out any](stage string, v In, fn func(In) (Out, error)) (out Out, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = &PanicError{Stage: stage, Input: v, Value: p, Stack: debug.Stack()}
+		}
+	}()
+	return fn(v)
+}
+
+// PanicPolicy decides what a stage that recovers panics does next.
+type PanicPolicy int
+
+const (
+	// AbortOnPanic stops the flow, Wait returns the *PanicError.
+	AbortOnPanic PanicPolicy = iota
+	// SkipOnPanic drops the input and carries on.
+	SkipOnPanic
+	// RestartOnPanic drops the input and replaces the goroutine that
+	// panicked with a fresh one, up to MaxRestarts times over the
+	// stage's run, after which the flow is stopped as by AbortOnPanic.
+	RestartOnPanic
+)
+
+// String implements fmt.Stringer.
+func (p PanicPolicy) String() string {
+	switch p {
+	case AbortOnPanic:
+		return "abort"
+	case SkipOnPanic:
+		return "skip"
+	default:
+		return "restart"
+	}
+}
+
+// RecoverPanics recovers panics in a stage's function, which otherwise
+// crash the process, turning each into a *PanicError and acting on it
+// according to policy.  A skipped or restarted input is recorded as a
+// dead letter when the stage has the DeadLetter ErrorPolicy.
+func RecoverPanics(policy PanicPolicy) StageOption {
+	return func(c *stageConfig) {
+		c.recover = true
+		c.panics = policy
+	}
+}
+
+// MaxRestarts caps the goroutine restarts RestartOnPanic allows a
+// stage, 3 unless overridden.
+func MaxRestarts(n int) StageOption {
+	return func(c *stageConfig) {
+		c.maxRestarts = max(n, 0)
+	}
+}
+
+// call runs s on v, recovering a panic if the stage asks for it.
+func call[In, Out any](ctx context.Context, cfg stageConfig, s Stage[In, Out], v In) (Out, error) {
+	if !cfg.recover {
+		return s(ctx, v)
+	}
+	return Protect(cfg.name, v, func(v In) (Out, error) {
+		return s(ctx, v)
+	})
+}
+
+// restartError asks supervise to replace the goroutine returning it.
+type restartError struct {
+	*PanicError
+}
+
+// supervise runs the stage's workers, each a copy of work, counting
+// them in wg.  A worker that returns a restartError is replaced while
+// the stage has restarts left, any other error is the runner's.
+func supervise(r *runner, cfg stageConfig, wg *sync.WaitGroup, work func() error) {
+	var restarts atomic.Int64
+	var start func()
+	start = func() {
+		wg.Add(1)
+		r.spawn(func() error {
+			defer wg.Done()
+			err := work()
+			re, ok := err.(*restartError)
+			if !ok {
+				return err
+			}
+			if restarts.Add(1) > int64(cfg.maxRestarts) {
+				return re.PanicError
+			}
+			start()
+			return nil
+		})
+	}
+	for range cfg.workers {
+		start()
+	}
+}
+
+// Supervisor recovers panics for the channel stages, which unlike a
+// Flow's have no error to return, and for the goroutines feeding them.
+// Each panic is kept as a *PanicError and acted on according to the
+// supervisor's policy:
+//
+//	s := pipeline.NewSupervisor(pipeline.SkipOnPanic, 0)
+//	done = s.Done(done)
+//	for v := range pipeline.SupervisedMap(done, upstream, parse, s) {
+//		...
+//	}
+//	for _, p := range s.Panics() {
+//		log.Print(p)
+//	}
+//
+// Stages such as Merge run none of the caller's code, a panic in a fan
+// in comes from the goroutines feeding it, which Run protects.  Once
+// the supervisor aborts, the channel Done returned is closed so that
+// every stage given it stops.  A nil *Supervisor recovers nothing.
+type Supervisor struct {
+	policy      PanicPolicy
+	maxRestarts int
+
+	mu       sync.Mutex
+	panics   []*PanicError
+	restarts int
+	err      error
+	aborted  chan struct{}
+}
+
+// NewSupervisor returns a supervisor acting on panics according to
+// policy, allowing RestartOnPanic maxRestarts restarts in all, after
+// which it aborts.
+func NewSupervisor(policy PanicPolicy, maxRestarts int) *Supervisor {
+	return &Supervisor{policy: policy, maxRestarts: max(maxRestarts, 0), aborted: make(chan struct{})}
+}
+
+// Panics returns the panics recovered so far, oldest first.
+func (s *Supervisor) Panics() []*PanicError {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.panics)
+}
+
+// Err is the *PanicError the supervisor aborted on, nil while it has
+// not.
+func (s *Supervisor) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Done returns a channel closed once done is or the supervisor aborts,
+// to be handed to the stages in its place.
+func (s *Supervisor) Done(done <-chan struct{}) <-chan struct{} {
+	if s == nil {
+		return done
+	}
+	out := make(chan struct{})
+	go func() {
+		defer close(out)
+		select {
+		case <-done:
+		case <-s.aborted:
+		}
+	}()
+	return out
+}
+
+// Run calls fn, recovering a panic in it.  Under RestartOnPanic fn is
+// called afresh while restarts are left, under SkipOnPanic Run just
+// returns, as it does once the supervisor aborts.
+func (s *Supervisor) Run(name string, fn func()) {
+	if s == nil {
+		fn()
+		return
+	}
+	for {
+		_, err := Protect(name, nil, func(any) (struct{}, error) {
+			fn()
+			return struct{}{}, nil
+		})
+		if err == nil || s.recovered(err) != RestartOnPanic {
+			return
+		}
+	}
+}
+
+// recovered records err, a *PanicError, returning what is to be done
+// about it: AbortOnPanic once the supervisor has aborted, otherwise
+// its policy.
+func (s *Supervisor) recovered(err error) PanicPolicy {
+	pe := err.(*PanicError)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.panics = append(s.panics, pe)
+	if s.err != nil {
+		return AbortOnPanic
+	}
+	policy := s.policy
+	if policy == RestartOnPanic {
+		if s.restarts++; s.restarts > s.maxRestarts {
+			policy = AbortOnPanic
+		}
+	}
+	if policy == AbortOnPanic {
+		s.err = pe
+		close(s.aborted)
+	}
+	return policy
+}
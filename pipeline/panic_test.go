@@ -0,0 +1,205 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// panicky panics on multiples of three, passing other values through.
+func panicky(v int) int {
+	if v%3 == 0 {
+		panic(errOdd)
+	}
+	return v
+}
+
+// survivors are the values of 0 to 9 panicky lets through.
+var survivors = []int{1, 2, 4, 5, 7, 8}
+
+func TestProtect(t *testing.T) {
+	got, err := Protect("double", 2, func(v int) (int, error) { return 2 * v, nil })
+	if got != 4 || err != nil {
+		t.Errorf("got %d, %v, want 4, nil", got, err)
+	}
+
+	_, err = Protect("panicky", 3, func(v int) (int, error) { return panicky(v), nil })
+	var pe *PanicError
+	if !errors.As(err, &pe) {
+		t.Fatalf("got %v, want a *PanicError", err)
+	}
+	if pe.Stage != "panicky" || pe.Input != 3 || len(pe.Stack) == 0 || !errors.Is(err, errOdd) {
+		t.Errorf("got %+v, want panicky on 3 with a stack, unwrapping to %v", pe, errOdd)
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	stage := func(_ context.Context, v int) (int, error) { return panicky(v), nil }
+	tests := []struct {
+		name      string
+		opts      []StageOption
+		want      []int // nil when the flow aborts
+		failInput int   // the input the flow aborts on
+	}{
+		{"skip", []StageOption{RecoverPanics(SkipOnPanic)}, survivors, 0},
+		{"restart", []StageOption{RecoverPanics(RestartOnPanic), MaxRestarts(4)}, survivors, 0},
+		{"restart over the limit", []StageOption{RecoverPanics(RestartOnPanic), MaxRestarts(3)}, nil, 9},
+		{"restart by default up to three times", []StageOption{RecoverPanics(RestartOnPanic)}, nil, 9},
+		{"abort", []StageOption{RecoverPanics(AbortOnPanic)}, nil, 0},
+		{"restarted workers", []StageOption{RecoverPanics(RestartOnPanic), Workers(3), MaxRestarts(4)}, survivors, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			noLeaks(t)
+			got, err := run(t, From(upTo(10)).Then(stage, append([]StageOption{Named("panicky")}, tt.opts...)...))
+			if tt.want == nil {
+				var pe *PanicError
+				if !errors.As(err, &pe) || pe.Input != tt.failInput || pe.Stage != "panicky" {
+					t.Fatalf("got %v, want the panic on %d", err, tt.failInput)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("dead letters", func(t *testing.T) {
+		for _, policy := range []PanicPolicy{SkipOnPanic, RestartOnPanic} {
+			var letters DeadLetters
+			_, err := run(t, From(upTo(10)).Then(stage, RecoverPanics(policy), MaxRestarts(10), OnError(DeadLetter, &letters)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := inputs(letters.Letters()); !slices.Equal(got, []int{0, 3, 6, 9}) {
+				t.Errorf("%v: got letters for %v, want 0, 3, 6 and 9", policy, got)
+			}
+		}
+	})
+}
+
+func TestSupervisedMap(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      PanicPolicy
+		maxRestarts int
+		want        []int
+		panics      int
+		abortInput  int // the input the supervisor aborts on, -1 if it does not
+	}{
+		{"skip", SkipOnPanic, 0, survivors, 4, -1},
+		{"restart", RestartOnPanic, 4, survivors, 4, -1},
+		{"restart over the limit", RestartOnPanic, 3, survivors, 4, 9},
+		{"abort", AbortOnPanic, 0, nil, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			noLeaks(t)
+			quit := make(chan struct{})
+			defer close(quit)
+			s := NewSupervisor(tt.policy, tt.maxRestarts)
+			done := s.Done(quit)
+			got := collect(t, SupervisedMap(done, Generator(done, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9), panicky, s))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if n := len(s.Panics()); n != tt.panics {
+				t.Errorf("recovered %d panics, want %d", n, tt.panics)
+			}
+			err := s.Err()
+			if tt.abortInput < 0 {
+				if err != nil {
+					t.Errorf("aborted with %v", err)
+				}
+				return
+			}
+			var pe *PanicError
+			if !errors.As(err, &pe) || pe.Input != tt.abortInput {
+				t.Fatalf("got %v, want an abort on %d", err, tt.abortInput)
+			}
+			closes(t, done)
+		})
+	}
+
+	t.Run("nil supervisor", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		defer close(done)
+		got := collect(t, SupervisedMap(done, Generator(done, 1, 2), panicky, nil))
+		if !slices.Equal(got, []int{1, 2}) {
+			t.Errorf("got %v, want [1 2]", got)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		out := SupervisedMap(done, Repeat(done, 1), panicky, NewSupervisor(SkipOnPanic, 0))
+		<-out
+		close(done)
+		closes(t, out)
+	})
+}
+
+func TestSupervisorRun(t *testing.T) {
+	// flaky panics the first fails times it is called.
+	flaky := func(calls *int, fails int) func() {
+		return func() {
+			if *calls++; *calls <= fails {
+				panic("flaky")
+			}
+		}
+	}
+	tests := []struct {
+		name        string
+		policy      PanicPolicy
+		maxRestarts int
+		fails       int
+		wantCalls   int
+		aborts      bool
+	}{
+		{"no panic", AbortOnPanic, 0, 0, 1, false},
+		{"skip", SkipOnPanic, 0, 5, 1, false},
+		{"restart", RestartOnPanic, 3, 2, 3, false},
+		{"restart over the limit", RestartOnPanic, 3, 5, 4, true},
+		{"abort", AbortOnPanic, 0, 5, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			s := NewSupervisor(tt.policy, tt.maxRestarts)
+			s.Run("flaky", flaky(&calls, tt.fails))
+			if calls != tt.wantCalls {
+				t.Errorf("made %d calls, want %d", calls, tt.wantCalls)
+			}
+			if aborted := s.Err() != nil; aborted != tt.aborts {
+				t.Errorf("aborted is %v, want %v", aborted, tt.aborts)
+			}
+		})
+	}
+
+	t.Run("restarts are shared", func(t *testing.T) {
+		s := NewSupervisor(RestartOnPanic, 2)
+		var first, second int
+		s.Run("first", flaky(&first, 2))
+		s.Run("second", flaky(&second, 1))
+		if s.Err() == nil || first != 3 || second != 1 {
+			t.Errorf("made %d and %d calls, aborted with %v, want 3 and 1 then an abort", first, second, s.Err())
+		}
+	})
+
+	t.Run("nil supervisor", func(t *testing.T) {
+		var calls int
+		var s *Supervisor
+		s.Run("once", flaky(&calls, 0))
+		if calls != 1 {
+			t.Errorf("made %d calls, want 1", calls)
+		}
+	})
+}
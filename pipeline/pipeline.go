@@ -0,0 +1,113 @@
+// Package pipeline holds the generic building blocks the examples in
+// this repository are built from.  Each stage takes a done channel and
+// one or more upstream channels and returns its downstream channel, so
+// stages compose by passing the output of one to the next:
+//
+//	done := make(chan struct{})
+//	defer close(done)
+//	for v := range pipeline.Map(done, pipeline.Generator(done, 1, 2, 3), double) {
+//		fmt.Println(v)
+//	}
+//
+// Every stage runs in goroutines of its own and closes its downstream
+// channel once its upstream is exhausted.  Closing done cancels the
+// stage, whatever it is blocked on, so a consumer that stops early
+// never leaks the goroutines feeding it.  Closing done is the only way
+// to cancel, stages never close or drain channels they did not create.
+//
+// Flow builds the same kind of pipeline from plain functions instead,
+// with a context for cancellation, a number of workers per stage and
+// the first error from any stage stopping the lot, see From.
+package pipeline
+
+// Generator sends values downstream, one at a time, then closes.
+func Generator[T any](done <-chan struct{}, values ...T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for _, v := range values {
+			select {
+			case out <- v:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Repeat sends values downstream over and over until done is closed.
+// With no values it closes straight away.
+func Repeat[T any](done <-chan struct{}, values ...T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		if len(values) == 0 {
+			return
+		}
+		for {
+			for _, v := range values {
+				select {
+				case out <- v:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// Take forwards the first n values from upstream, then closes.  It
+// stops reading once it has them, so an endless upstream such as
+// Repeat is left for done to cancel.
+func Take[T any](done <-chan struct{}, upstream <-chan T, n int) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for range n {
+			select {
+			case v, ok := <-upstream:
+				if !ok {
+					return
+				}
+				select {
+				case out <- v:
+				case <-done:
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// OrDone forwards upstream until it is exhausted or done is closed,
+// whichever comes first.  It lets a consumer range over a channel it
+// does not control without blocking past cancellation:
+//
+//	for v := range pipeline.OrDone(done, c) { ... }
+func OrDone[T any](done <-chan struct{}, upstream <-chan T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case v, ok := <-upstream:
+				if !ok {
+					return
+				}
+				select {
+				case out <- v:
+				case <-done:
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
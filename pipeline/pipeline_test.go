@@ -0,0 +1,199 @@
+package pipeline
+
+import (
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+)
+
+// wait is how long a stage gets to do something it should do at once.
+const wait = time.Second
+
+// collect reads c until it is closed.
+func collect[T any](t *testing.T, c <-chan T) []T {
+	t.Helper()
+	var got []T
+	timeout := time.After(wait)
+	for {
+		select {
+		case v, ok := <-c:
+			if !ok {
+				return got
+			}
+			got = append(got, v)
+		case <-timeout:
+			t.Fatalf("channel not closed after %v, got %v so far", wait, got)
+		}
+	}
+}
+
+// closes fails t unless c is closed soon, whatever is left on it is
+// discarded.
+func closes[T any](t *testing.T, c <-chan T) {
+	t.Helper()
+	timeout := time.After(wait)
+	for {
+		select {
+		case _, ok := <-c:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("channel not closed %v after done", wait)
+		}
+	}
+}
+
+// noLeaks fails t if the test leaves more goroutines running than it
+// started with, giving those on their way out a moment to finish.
+func noLeaks(t *testing.T) {
+	t.Helper()
+	before := runtime.NumGoroutine()
+	t.Cleanup(func() {
+		deadline := time.Now().Add(wait)
+		for runtime.NumGoroutine() > before {
+			if time.Now().After(deadline) {
+				buf := make([]byte, 1<<16)
+				t.Fatalf("%d goroutines leaked\n%s", runtime.NumGoroutine()-before, buf[:runtime.Stack(buf, true)])
+			}
+			time.Sleep(time.Millisecond)
+		}
+	})
+}
+
+// stalled returns a channel nothing is ever sent on or closed.
+func stalled[T any]() <-chan T {
+	return make(chan T)
+}
+
+func TestGenerator(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []int
+	}{
+		{"none", nil},
+		{"one", []int{1}},
+		{"many", []int{3, 1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			noLeaks(t)
+			done := make(chan struct{})
+			defer close(done)
+			if got := collect(t, Generator(done, tt.values...)); !slices.Equal(got, tt.values) {
+				t.Errorf("got %v, want %v", got, tt.values)
+			}
+		})
+	}
+
+	t.Run("cancelled", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		out := Generator(done, 1, 2, 3)
+		<-out
+		close(done)
+		closes(t, out)
+	})
+}
+
+func TestRepeat(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []int
+		n      int
+		want   []int
+	}{
+		{"none", nil, 3, nil},
+		{"one", []int{7}, 3, []int{7, 7, 7}},
+		{"cycles", []int{1, 2}, 5, []int{1, 2, 1, 2, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			noLeaks(t)
+			done := make(chan struct{})
+			defer close(done)
+			if got := collect(t, Take(done, Repeat(done, tt.values...), tt.n)); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("cancelled", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		out := Repeat(done, 1)
+		<-out
+		close(done)
+		closes(t, out)
+	})
+}
+
+func TestTake(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream []int
+		n        int
+		want     []int
+	}{
+		{"zero", []int{1, 2}, 0, nil},
+		{"fewer", []int{1, 2, 3}, 2, []int{1, 2}},
+		{"all", []int{1, 2}, 2, []int{1, 2}},
+		{"more than there are", []int{1, 2}, 5, []int{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			noLeaks(t)
+			done := make(chan struct{})
+			defer close(done)
+			if got := collect(t, Take(done, Generator(done, tt.upstream...), tt.n)); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("cancelled", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		out := Take(done, stalled[int](), 5)
+		close(done)
+		closes(t, out)
+	})
+}
+
+func TestOrDone(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream []int
+	}{
+		{"empty", nil},
+		{"values", []int{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			noLeaks(t)
+			done := make(chan struct{})
+			defer close(done)
+			if got := collect(t, OrDone(done, Generator(done, tt.upstream...))); !slices.Equal(got, tt.upstream) {
+				t.Errorf("got %v, want %v", got, tt.upstream)
+			}
+		})
+	}
+
+	t.Run("cancelled waiting to receive", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		out := OrDone(done, stalled[int]())
+		close(done)
+		closes(t, out)
+	})
+
+	t.Run("cancelled waiting to send", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		out := OrDone(done, Repeat(done, 1))
+		<-out
+		close(done)
+		closes(t, out)
+	})
+}
@@ -0,0 +1,191 @@
+package pipeline
+
+import (
+	"reflect"
+	"slices"
+)
+
+// Weighted is an inbound channel of a WeightedMerge with its share.
+type Weighted[T any] struct {
+	C      <-chan T
+	Weight int // < 1 is treated as 1
+}
+
+// MergeOption configures PriorityMerge and WeightedMerge.
+type MergeOption func(*mergeConfig)
+
+// mergeConfig holds the tunables of a scheduled merge.
+type mergeConfig struct {
+	starvation int // 0 lets a channel be passed over forever
+}
+
+// StarvationLimit serves a channel that has had a value waiting while
+// n others were sent ahead of it next, whatever its priority or weight,
+// so a busy channel can delay a quieter one but never shut it out.
+// n < 1 turns the protection off, which is the default.
+func StarvationLimit(n int) MergeOption {
+	return func(c *mergeConfig) {
+		c.starvation = max(n, 0)
+	}
+}
+
+// PriorityMerge is Merge in strict priority, inbound being given
+// highest priority first.  Whenever more than one channel has a value
+// waiting the value sent on is the highest priority channel's, so a
+// lower priority channel only gets a turn when those above it have
+// nothing to send, unless StarvationLimit says otherwise.
+//
+// Telling which channels are waiting means taking a value from each
+// that is, and holding on to it until it is picked, so a value taken
+// from a lower priority channel just before a higher one produces can
+// still go first.
+func PriorityMerge[T any](done <-chan struct{}, inbound []<-chan T, opts ...MergeOption) <-chan T {
+	return scheduledMerge(done, inbound, func(ready []int) int {
+		return ready[0]
+	}, opts...)
+}
+
+// WeightedMerge is Merge in weighted fair shares.  While every inbound
+// channel has values waiting each is sent on in proportion to its
+// weight, one of weight 3 three times as often as one of weight 1,
+// interleaved as evenly as the weights allow rather than in bursts.  A
+// channel with nothing waiting gives up its share to the others and
+// earns no credit for later, so a channel that has been idle does not
+// get to monopolise out once it has values again.
+//
+// For both merges a channel is waiting if a receive from it would not
+// block at the time of the pick.  A goroutine sending on an unbuffered
+// channel has to be scheduled again after every value before it is
+// waiting again, so give the inbound channels a buffer, Buffer will do,
+// for priorities and shares to hold under load.
+func WeightedMerge[T any](done <-chan struct{}, inbound []Weighted[T], opts ...MergeOption) <-chan T {
+	chans := make([]<-chan T, len(inbound))
+	weights := make([]int, len(inbound))
+	for i, w := range inbound {
+		chans[i], weights[i] = w.C, max(w.Weight, 1)
+	}
+
+	// smooth weighted round robin, every waiting channel gains its
+	// weight and the one that has gained the most is served, paying
+	// back the weight of all of them.
+	current := make([]int, len(inbound))
+	return scheduledMerge(done, chans, func(ready []int) int {
+		best, total := ready[0], 0
+		for _, i := range ready {
+			current[i] += weights[i]
+			total += weights[i]
+			if current[i] > current[best] {
+				best = i
+			}
+		}
+		current[best] -= total
+		return best
+	}, opts...)
+}
+
+// scheduledMerge merges inbound, pick choosing which of the channels
+// with a value waiting, given in the order of inbound, goes next.
+//
+// Before every pick each channel is polled, without blocking, for a
+// value to park in its head, so the channels with a head are those
+// that were ready to send.  Only when none of them are does it wait,
+// on all of them at once with reflect.Select as MergeSelect does.
+func scheduledMerge[T any](done <-chan struct{}, inbound []<-chan T, pick func(ready []int) int, opts ...MergeOption) <-chan T {
+	var cfg mergeConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		// an exhausted channel is set to nil and skipped from then on.
+		chans := slices.Clone(inbound)
+		heads := make([]T, len(chans))
+		parked := make([]bool, len(chans))
+		skipped := make([]int, len(chans))
+		ready := make([]int, 0, len(chans))
+		for {
+			ready = ready[:0]
+			for i, c := range chans {
+				if !parked[i] && c != nil {
+					select {
+					case v, ok := <-c:
+						if ok {
+							heads[i], parked[i] = v, true
+						} else {
+							chans[i] = nil
+						}
+					default:
+					}
+				}
+				if parked[i] {
+					ready = append(ready, i)
+				}
+			}
+			if len(ready) == 0 {
+				i, v, ok := waitAny(done, chans)
+				switch {
+				case i < 0:
+					return
+				case ok:
+					heads[i], parked[i] = v, true
+				default:
+					chans[i] = nil
+				}
+				continue
+			}
+
+			next := -1
+			if cfg.starvation > 0 {
+				for _, i := range ready {
+					if skipped[i] >= cfg.starvation && (next < 0 || skipped[i] > skipped[next]) {
+						next = i
+					}
+				}
+			}
+			if next < 0 {
+				next = pick(ready)
+			}
+			for _, i := range ready {
+				skipped[i]++
+			}
+			skipped[next] = 0
+
+			v := heads[next]
+			var zero T
+			heads[next], parked[next] = zero, false
+			select {
+			case out <- v:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// waitAny blocks until one of the non nil chans has a value, or is
+// closed, returning its index and the comma ok receive.  The index is
+// -1 once done is closed, or if there are no chans left to wait on.
+func waitAny[T any](done <-chan struct{}, chans []<-chan T) (int, T, bool) {
+	var zero T
+	cases := []reflect.SelectCase{{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)}}
+	index := []int{-1}
+	for i, c := range chans {
+		if c != nil {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c)})
+			index = append(index, i)
+		}
+	}
+	if len(cases) == 1 {
+		return -1, zero, false
+	}
+	chosen, rv, ok := reflect.Select(cases)
+	if chosen == 0 || !ok {
+		return index[chosen], zero, false
+	}
+	// the comma ok form leaves v zero for a nil interface value.
+	v, _ := rv.Interface().(T)
+	return index[chosen], v, true
+}
@@ -0,0 +1,203 @@
+package pipeline
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// labelled is a pre-filled inbound channel holding n copies of label,
+// so the merged output tells which channel every value came from.
+func labelled(label, n int) <-chan int {
+	return filled(slices.Repeat([]int{label}, n)...)
+}
+
+// shares counts the values from each label in the first n of got.
+func shares(got []int, n, labels int) []int {
+	counts := make([]int, labels)
+	for _, v := range got[:n] {
+		counts[v]++
+	}
+	return counts
+}
+
+// longestRun is the most values with label sent one after the other.
+func longestRun(got []int, label int) int {
+	longest, run := 0, 0
+	for _, v := range got {
+		if v != label {
+			run = 0
+			continue
+		}
+		run++
+		longest = max(longest, run)
+	}
+	return longest
+}
+
+func TestPriorityMerge(t *testing.T) {
+	t.Run("strict", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		defer close(done)
+		got := collect(t, PriorityMerge(done, []<-chan int{labelled(0, 50), labelled(1, 50), labelled(2, 50)}))
+		want := slices.Concat(slices.Repeat([]int{0}, 50), slices.Repeat([]int{1}, 50), slices.Repeat([]int{2}, 50))
+		if !slices.Equal(got, want) {
+			t.Errorf("got %v, want every value of a channel before any of the next", got)
+		}
+	})
+
+	for _, limit := range []int{1, 3, 10} {
+		t.Run(fmt.Sprintf("starvation limit %d", limit), func(t *testing.T) {
+			noLeaks(t)
+			done := make(chan struct{})
+			defer close(done)
+			got := collect(t, PriorityMerge(done, []<-chan int{labelled(0, 1000), labelled(1, 50)}, StarvationLimit(limit)))
+			if len(got) != 1050 {
+				t.Fatalf("got %d values, want 1050", len(got))
+			}
+			// the low priority channel, waiting throughout, is passed
+			// over limit times at most before it is served.
+			last := slices.Index(got, 0)
+			for i, v := range got {
+				if v != 1 {
+					continue
+				}
+				if skips := i - last - 1; skips > limit {
+					t.Fatalf("value %d sent after %d skips, want at most %d", i, skips, limit)
+				}
+				last = i
+			}
+			if n := shares(got, 50*(limit+1), 2)[1]; n != 50 {
+				t.Errorf("%d of 50 low priority values sent in the first %d, want all of them", n, 50*(limit+1))
+			}
+		})
+	}
+
+	t.Run("no limit", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		defer close(done)
+		got := collect(t, PriorityMerge(done, []<-chan int{labelled(0, 100), labelled(1, 10)}, StarvationLimit(0)))
+		if i := slices.Index(got, 1); i != 100 {
+			t.Errorf("low priority first sent at %d, want only once the other ran out at 100", i)
+		}
+	})
+}
+
+func TestWeightedMerge(t *testing.T) {
+	tests := []struct {
+		weights []int
+		want    []int // shares, a weight below one counting as one
+	}{
+		{[]int{3, 1}, []int{3, 1}},
+		{[]int{1, 1}, []int{1, 1}},
+		{[]int{3, 2, 1}, []int{3, 2, 1}},
+		{[]int{0, -1, 2}, []int{1, 1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.weights), func(t *testing.T) {
+			noLeaks(t)
+			done := make(chan struct{})
+			defer close(done)
+			const per = 1000
+			inbound := make([]Weighted[int], len(tt.weights))
+			for i, w := range tt.weights {
+				inbound[i] = Weighted[int]{C: labelled(i, per), Weight: w}
+			}
+			got := collect(t, WeightedMerge(done, inbound))
+			if len(got) != per*len(inbound) {
+				t.Fatalf("got %d values, want %d", len(got), per*len(inbound))
+			}
+
+			// while every channel has values, so before the first to
+			// get the most runs out, each gets its share of every round.
+			round, heaviest := 0, 0
+			for i, w := range tt.want {
+				round += w
+				if w > tt.want[heaviest] {
+					heaviest = i
+				}
+			}
+			n := per / tt.want[heaviest] * round
+			counts := shares(got, n, len(inbound))
+			for i, c := range counts {
+				want := n / round * tt.want[i]
+				if c < want*95/100 || c > want*105/100 {
+					t.Errorf("channel %d sent %d of the first %d, want about %d: %v", i, c, n, want, counts)
+				}
+			}
+			// interleaved rather than in bursts.
+			if run := longestRun(got[:n], heaviest); run > tt.want[heaviest] {
+				t.Errorf("channel %d sent %d in a row, want at most %d", heaviest, run, tt.want[heaviest])
+			}
+		})
+	}
+
+	t.Run("idle channel gives up its share", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		defer close(done)
+		got := collect(t, WeightedMerge(done, []Weighted[int]{{C: labelled(0, 100), Weight: 1}, {C: labelled(1, 0), Weight: 10}}))
+		if counts := shares(got, len(got), 2); counts[0] != 100 || counts[1] != 0 {
+			t.Errorf("got %v, want the 100 values of the only busy channel", counts)
+		}
+	})
+
+	t.Run("starvation limit", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		defer close(done)
+		got := collect(t, WeightedMerge(done, []Weighted[int]{{C: labelled(0, 1000), Weight: 100}, {C: labelled(1, 100), Weight: 1}}, StarvationLimit(5)))
+		if run := longestRun(got[:500], 0); run > 5 {
+			t.Errorf("heavy channel sent %d in a row, want at most 5", run)
+		}
+	})
+}
+
+func TestScheduledMergeUnbuffered(t *testing.T) {
+	noLeaks(t)
+	done := make(chan struct{})
+	defer close(done)
+	// values arrive one at a time, every one of them still gets through.
+	got := collect(t, WeightedMerge(done, []Weighted[int]{
+		{C: Generator(done, 1, 2, 3), Weight: 2},
+		{C: Generator(done, 4, 5), Weight: 1},
+	}))
+	slices.Sort(got)
+	if !slices.Equal(got, []int{1, 2, 3, 4, 5}) {
+		t.Errorf("got %v, want 1 to 5", got)
+	}
+	if got := collect(t, PriorityMerge[int](done, nil)); len(got) != 0 {
+		t.Errorf("got %v from no channels, want nothing", got)
+	}
+}
+
+func TestScheduledMergeCancelled(t *testing.T) {
+	merges := map[string]func(done <-chan struct{}, inbound []<-chan int) <-chan int{
+		"priority": func(done <-chan struct{}, inbound []<-chan int) <-chan int {
+			return PriorityMerge(done, inbound, StarvationLimit(2))
+		},
+		"weighted": func(done <-chan struct{}, inbound []<-chan int) <-chan int {
+			return WeightedMerge(done, []Weighted[int]{{C: inbound[0], Weight: 2}, {C: inbound[1], Weight: 1}})
+		},
+	}
+	for name, merge := range merges {
+		t.Run(name+" stalled", func(t *testing.T) {
+			noLeaks(t)
+			done := make(chan struct{})
+			out := merge(done, []<-chan int{stalled[int](), stalled[int]()})
+			close(done)
+			closes(t, out)
+		})
+
+		t.Run(name+" blocked on send", func(t *testing.T) {
+			noLeaks(t)
+			done := make(chan struct{})
+			out := merge(done, []<-chan int{Repeat(done, 1), Repeat(done, 2)})
+			<-out
+			close(done)
+			closes(t, out)
+		})
+	}
+}
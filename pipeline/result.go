@@ -0,0 +1,64 @@
+package pipeline
+
+import "context"
+
+// Result is a value, or the error that stopped it being produced, so
+// failures can travel down a channel alongside the values instead of
+// through a channel of their own.
+type Result[T any] struct {
+	Value T
+	Err   error
+}
+
+// Unwrap returns the value and error of the result.
+func (r Result[T]) Unwrap() (T, error) {
+	return r.Value, r.Err
+}
+
+// TryMap sends the Result of fn for each upstream value downstream, in
+// order.  Unlike a failing Flow stage, an error stops nothing, it is
+// left to the consumer to decide what to do with it.
+func TryMap[In, Out any](done <-chan struct{}, upstream <-chan In, fn func(In) (Out, error)) <-chan Result[Out] {
+	return Map(done, upstream, func(v In) Result[Out] {
+		out, err := fn(v)
+		return Result[Out]{out, err}
+	})
+}
+
+// MapOK is TryMap over Results.  Failed results are passed on as they
+// are, successful ones have fn applied, so a chain of MapOK stages
+// carries the first error each value hit through to the end.
+func MapOK[In, Out any](done <-chan struct{}, upstream <-chan Result[In], fn func(In) (Out, error)) <-chan Result[Out] {
+	return Map(done, upstream, func(r Result[In]) Result[Out] {
+		if r.Err != nil {
+			return Result[Out]{Err: r.Err}
+		}
+		out, err := fn(r.Value)
+		return Result[Out]{out, err}
+	})
+}
+
+// Try is TryMap for a Flow: the stage it returns passes the errors of
+// s on in its Results rather than failing, so no ErrorPolicy applies
+// to them.  Only the flow being cancelled still stops it.
+func Try[In, Out any](s Stage[In, Out]) Stage[In, Result[Out]] {
+	return func(ctx context.Context, v In) (Result[Out], error) {
+		out, err := s(ctx, v)
+		if ctx.Err() != nil {
+			return Result[Out]{}, ctx.Err()
+		}
+		return Result[Out]{out, err}, nil
+	}
+}
+
+// OK is MapOK for a Flow: the stage it returns applies s to successful
+// Results and passes failed ones on as they are.
+func OK[In, Out any](s Stage[In, Out]) Stage[Result[In], Result[Out]] {
+	return Try(func(ctx context.Context, r Result[In]) (Out, error) {
+		if r.Err != nil {
+			var zero Out
+			return zero, r.Err
+		}
+		return s(ctx, r.Value)
+	})
+}
@@ -0,0 +1,87 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+// half halves even numbers and fails on odd ones.
+func half(v int) (int, error) {
+	if v%2 != 0 {
+		return 0, errOdd
+	}
+	return v / 2, nil
+}
+
+// checkResults fails t unless got holds the values of want in order,
+// with an empty string standing for a result that failed with errOdd.
+func checkResults(t *testing.T, got []Result[string], want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %q", got, want)
+	}
+	for i, r := range got {
+		v, err := r.Unwrap()
+		if want[i] == "" && !errors.Is(err, errOdd) || want[i] != "" && (err != nil || v != want[i]) {
+			t.Errorf("result %d is %q, %v, want %q", i, v, err, want[i])
+		}
+	}
+}
+
+func TestTryMapAndMapOK(t *testing.T) {
+	noLeaks(t)
+	done := make(chan struct{})
+	defer close(done)
+	// 1 fails the first halving, 6 the second and the error is carried
+	// through the stages after it.
+	halved := MapOK(done, TryMap(done, Generator(done, 4, 1, 8, 6), half), half)
+	got := collect(t, MapOK(done, halved, func(v int) (string, error) {
+		return strconv.Itoa(v), nil
+	}))
+	checkResults(t, got, []string{"1", "", "2", ""})
+
+	t.Run("cancelled", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		out := MapOK(done, TryMap(done, Repeat(done, 2), half), half)
+		<-out
+		close(done)
+		closes(t, out)
+	})
+}
+
+func TestTryAndOK(t *testing.T) {
+	noLeaks(t)
+	itoa := func(_ context.Context, v int) (string, error) {
+		return strconv.Itoa(v), nil
+	}
+	halve := func(_ context.Context, v int) (int, error) {
+		return half(v)
+	}
+	// failures reach the sink as Results instead of stopping the flow.
+	f := Then(Then(Then(From(upTo(9)), Try(halve)), OK(halve), Workers(3), Ordered()), OK(itoa))
+	got, err := run(t, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, got, []string{"0", "", "", "", "1", "", "", "", "2"})
+
+	t.Run("cancelled", func(t *testing.T) {
+		noLeaks(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		blocked := func(ctx context.Context, v int) (int, error) {
+			cancel()
+			<-ctx.Done()
+			return v, nil
+		}
+		err := Then(From(upTo(3)), Try(blocked)).Sink(ctx, func(context.Context, Result[int]) error {
+			t.Error("a cancelled value was passed on")
+			return nil
+		}).Wait()
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v, want %v", err, context.Canceled)
+		}
+	})
+}
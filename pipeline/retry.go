@@ -0,0 +1,223 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrRetryBudget is wrapped by the error of a stage that gave up early
+// because its RetryBudget ran out.
+var ErrRetryBudget = errors.New("retry budget exhausted")
+
+// Jitter decides how the wait between attempts is randomised, so
+// that callers failing together do not all retry together.
+type Jitter int
+
+const (
+	// FullJitter waits anywhere between zero and the exponential
+	// backoff for the attempt.
+	FullJitter Jitter = iota
+	// DecorrelatedJitter waits anywhere between the base delay and
+	// three times the previous wait, growing less predictably than
+	// FullJitter while still backing off.
+	DecorrelatedJitter
+)
+
+// RetryOption configures Retry.
+type RetryOption func(*retryConfig)
+
+// retryConfig holds the tunables of a Retry.
+type retryConfig struct {
+	attempts  int
+	base, max time.Duration
+	jitter    Jitter
+	timeout   time.Duration // 0 leaves attempts to the stage's context
+	retryable func(error) bool
+	budget    *RetryBudget
+}
+
+// MaxAttempts tries each input at most n times in all, n < 1 is
+// treated as 1.  The default is 3.
+func MaxAttempts(n int) RetryOption {
+	return func(c *retryConfig) {
+		c.attempts = max(n, 1)
+	}
+}
+
+// Backoff waits base before the first retry, doubling for each retry
+// after it up to max, before jitter.  The default is 100ms up to 10s.
+func Backoff(base, max time.Duration) RetryOption {
+	return func(c *retryConfig) {
+		c.base, c.max = base, max
+	}
+}
+
+// WithJitter sets how the backoff is randomised, FullJitter unless
+// overridden.
+func WithJitter(j Jitter) RetryOption {
+	return func(c *retryConfig) {
+		c.jitter = j
+	}
+}
+
+// AttemptTimeout gives each attempt a context of its own that expires
+// after d, a timed out attempt counts as a retryable failure.
+func AttemptTimeout(d time.Duration) RetryOption {
+	return func(c *retryConfig) {
+		c.timeout = d
+	}
+}
+
+// RetryIf retries only the errors retryable returns true for, any
+// other error is returned straight away.  By default every error is
+// retried.
+func RetryIf(retryable func(error) bool) RetryOption {
+	return func(c *retryConfig) {
+		c.retryable = retryable
+	}
+}
+
+// WithBudget draws every retry from b, which may be shared between
+// stages calling the same dependency.
+func WithBudget(b *RetryBudget) RetryOption {
+	return func(c *retryConfig) {
+		c.budget = b
+	}
+}
+
+// Retry wraps s so that an input it fails on is tried again, waiting
+// an exponentially growing, jittered, time between attempts.  Once the
+// attempts, or the budget, run out the last error is returned, wrapped,
+// and the wrapped stage's ErrorPolicy decides what happens to the
+// input:
+//
+//	fetch := pipeline.Retry(fetch, pipeline.MaxAttempts(5), pipeline.AttemptTimeout(time.Second))
+//	flow := pipeline.Then(urls, fetch, pipeline.OnError(pipeline.DeadLetter, letters))
+//
+// Cancelling the flow's context stops any wait between attempts.
+func Retry[In, Out any](s Stage[In, Out], opts ...RetryOption) Stage[In, Out] {
+	cfg := retryConfig{
+		attempts:  3,
+		base:      100 * time.Millisecond,
+		max:       10 * time.Second,
+		retryable: func(error) bool { return true },
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return func(ctx context.Context, v In) (Out, error) {
+		cfg.budget.deposit()
+		var wait time.Duration
+		for attempt := 1; ; attempt++ {
+			out, err := try(ctx, cfg.timeout, s, v)
+			switch {
+			case err == nil:
+				return out, nil
+			case ctx.Err() != nil:
+				return out, err
+			case !cfg.retryable(err):
+				return out, err
+			case attempt == cfg.attempts:
+				return out, fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+			case !cfg.budget.withdraw():
+				return out, fmt.Errorf("%w after %d attempts: %w", ErrRetryBudget, attempt, err)
+			}
+
+			wait = cfg.backoff(attempt, wait)
+			t := time.NewTimer(wait)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return out, ctx.Err()
+			}
+		}
+	}
+}
+
+// try makes a single attempt, within timeout if it is positive.
+func try[In, Out any](ctx context.Context, timeout time.Duration, s Stage[In, Out], v In) (Out, error) {
+	if timeout <= 0 {
+		return s(ctx, v)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return s(ctx, v)
+}
+
+// backoff is the wait before the retry that follows attempt, prev
+// being the wait before it.
+func (c retryConfig) backoff(attempt int, prev time.Duration) time.Duration {
+	if c.base <= 0 {
+		return 0
+	}
+	switch c.jitter {
+	case DecorrelatedJitter:
+		hi := max(3*prev, c.base+1)
+		return min(c.max, c.base+rand.N(hi-c.base))
+	default:
+		// c.base<<shift is only taken when it fits below c.max, a
+		// larger shift would overflow into a negative wait.
+		exp := c.max
+		if shift := attempt - 1; shift < 63 && c.base <= c.max>>shift {
+			exp = c.base << shift
+		}
+		return rand.N(exp + 1)
+	}
+}
+
+// RetryBudget caps retries at a fraction of the calls made, so that a
+// dependency that is down sees its load grow by that fraction rather
+// than multiply by the number of attempts.  Every call earns ratio of
+// a retry, up to a reserve of burst retries, and every retry spends
+// one.  It is safe for concurrent use.
+type RetryBudget struct {
+	ratio, burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	denied atomic.Int64
+}
+
+// NewRetryBudget returns a budget allowing ratio retries per call,
+// 0.1 being one retry for every ten calls, with burst retries saved
+// up at most.  It starts full.
+func NewRetryBudget(ratio, burst float64) *RetryBudget {
+	return &RetryBudget{ratio: ratio, burst: burst, tokens: burst}
+}
+
+// Denied is the number of retries the budget has refused.
+func (b *RetryBudget) Denied() int64 {
+	return b.denied.Load()
+}
+
+// deposit credits a call, a nil budget allows everything.
+func (b *RetryBudget) deposit() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.tokens+b.ratio, b.burst)
+}
+
+// withdraw spends a retry, reporting false if there is none to spend.
+func (b *RetryBudget) withdraw() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		b.denied.Add(1)
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,213 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// failing is a stage failing with err the first fails calls, counting
+// every call in calls.
+func failing(calls *int, fails int, err error) Stage[int, int] {
+	return func(_ context.Context, v int) (int, error) {
+		*calls++
+		if *calls <= fails {
+			return 0, err
+		}
+		return v, nil
+	}
+}
+
+// noWait retries straight away.
+var noWait = Backoff(0, 0)
+
+func TestBackoffFullJitter(t *testing.T) {
+	tests := []struct {
+		base, max time.Duration
+	}{
+		{time.Millisecond, time.Second},
+		{100 * time.Millisecond, 10 * time.Second},
+		{10 * time.Second, time.Hour},
+		{time.Hour, time.Hour},
+		{time.Second, time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%v up to %v", tt.base, tt.max), func(t *testing.T) {
+			c := retryConfig{base: tt.base, max: tt.max, jitter: FullJitter}
+			// far enough for the doubling to overflow if left unchecked.
+			for attempt := 1; attempt <= 100; attempt++ {
+				exp := tt.base
+				for n := 1; n < attempt && exp <= tt.max; n++ {
+					exp *= 2
+				}
+				hi := min(exp, tt.max)
+				for range 50 {
+					if got := c.backoff(attempt, 0); got < 0 || got > hi {
+						t.Fatalf("attempt %d waited %v, want 0 to %v", attempt, got, hi)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBackoffDecorrelatedJitter(t *testing.T) {
+	c := retryConfig{base: 10 * time.Millisecond, max: time.Second, jitter: DecorrelatedJitter}
+	for range 50 {
+		var prev time.Duration
+		for attempt := 1; attempt <= 100; attempt++ {
+			got := c.backoff(attempt, prev)
+			if got < c.base || got > c.max || got > max(3*prev, c.base+1) {
+				t.Fatalf("attempt %d after %v waited %v, want %v to %v and at most three times the last",
+					attempt, prev, got, c.base, c.max)
+			}
+			prev = got
+		}
+	}
+}
+
+func TestBackoffNoBase(t *testing.T) {
+	for _, j := range []Jitter{FullJitter, DecorrelatedJitter} {
+		c := retryConfig{max: time.Second, jitter: j}
+		if got := c.backoff(3, time.Second); got != 0 {
+			t.Errorf("jitter %d waited %v without a base, want 0", j, got)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	errFlaky := errors.New("flaky")
+	tests := []struct {
+		name      string
+		opts      []RetryOption
+		fails     int
+		wantCalls int
+		wantErr   bool
+	}{
+		{"succeeds first time", nil, 0, 1, false},
+		{"succeeds on a retry", nil, 2, 3, false},
+		{"gives up", nil, 5, 3, true},
+		{"more attempts", []RetryOption{MaxAttempts(5)}, 4, 5, false},
+		{"single attempt", []RetryOption{MaxAttempts(0)}, 1, 1, true},
+		{"retryable", []RetryOption{RetryIf(func(err error) bool { return errors.Is(err, errFlaky) })}, 2, 3, false},
+		{"not retryable", []RetryOption{RetryIf(func(error) bool { return false })}, 2, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			s := Retry(failing(&calls, tt.fails, errFlaky), append([]RetryOption{noWait}, tt.opts...)...)
+			got, err := s(context.Background(), 7)
+			if calls != tt.wantCalls {
+				t.Errorf("made %d calls, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, errFlaky) {
+					t.Errorf("got %v, want it to wrap %v", err, errFlaky)
+				}
+				return
+			}
+			if err != nil || got != 7 {
+				t.Errorf("got %d, %v, want 7, nil", got, err)
+			}
+		})
+	}
+}
+
+func TestRetryAttemptTimeout(t *testing.T) {
+	var calls int
+	// the first attempt hangs until its own deadline, the second answers.
+	s := Retry(func(ctx context.Context, v int) (int, error) {
+		calls++
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("attempt without a deadline")
+		}
+		if calls == 1 {
+			<-ctx.Done()
+			return 0, ctx.Err()
+		}
+		return v, nil
+	}, noWait, AttemptTimeout(10*time.Millisecond))
+	got, err := s(context.Background(), 7)
+	if err != nil || got != 7 || calls != 2 {
+		t.Errorf("got %d, %v after %d calls, want 7, nil after 2", got, err, calls)
+	}
+}
+
+func TestRetryCancelledWhileWaiting(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls int
+	s := Retry(func(context.Context, int) (int, error) {
+		calls++
+		cancel()
+		return 0, errOdd
+	}, Backoff(time.Hour, time.Hour))
+	errc := make(chan error, 1)
+	go func() {
+		_, err := s(ctx, 1)
+		errc <- err
+	}()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, errOdd) && !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v, want %v or %v", err, errOdd, context.Canceled)
+		}
+	case <-time.After(wait):
+		t.Fatal("still waiting to retry after the context was cancelled")
+	}
+	if calls != 1 {
+		t.Errorf("made %d calls, want 1", calls)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	t.Run("denied", func(t *testing.T) {
+		// a single retry in reserve and none earned.
+		b := NewRetryBudget(0, 1)
+		var calls int
+		s := Retry(failing(&calls, 10, errOdd), noWait, MaxAttempts(5), WithBudget(b))
+		_, err := s(context.Background(), 1)
+		if !errors.Is(err, ErrRetryBudget) || !errors.Is(err, errOdd) {
+			t.Errorf("got %v, want it to wrap %v and %v", err, ErrRetryBudget, errOdd)
+		}
+		if calls != 2 || b.Denied() != 1 {
+			t.Errorf("made %d calls with %d denied, want 2 with 1", calls, b.Denied())
+		}
+	})
+
+	t.Run("earned by calls", func(t *testing.T) {
+		// every other call earns a retry.
+		b := NewRetryBudget(0.5, 1)
+		b.withdraw()
+		var retried, denied int
+		for range 10 {
+			var calls int
+			_, err := Retry(failing(&calls, 1, errOdd), noWait, WithBudget(b))(context.Background(), 1)
+			switch {
+			case errors.Is(err, ErrRetryBudget):
+				denied++
+			case err == nil:
+				retried++
+			default:
+				t.Fatal(err)
+			}
+		}
+		if retried != 5 || denied != 5 || b.Denied() != 5 {
+			t.Errorf("retried %d and denied %d (%d counted), want 5 and 5", retried, denied, b.Denied())
+		}
+	})
+
+	t.Run("shared", func(t *testing.T) {
+		noLeaks(t)
+		b := NewRetryBudget(0, 3)
+		var calls int
+		_, err := run(t, From(upTo(10)).Then(Retry(failing(&calls, 1000, errOdd), noWait, MaxAttempts(2), WithBudget(b)), OnError(Skip, nil)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if calls != 13 || b.Denied() != 7 {
+			t.Errorf("made %d calls with %d denied, want 13 with 7", calls, b.Denied())
+		}
+	})
+}
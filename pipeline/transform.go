@@ -0,0 +1,262 @@
+package pipeline
+
+import (
+	"container/list"
+	"iter"
+	"time"
+)
+
+// Map sends fn of each upstream value downstream, in order.
+func Map[In, Out any](done <-chan struct{}, upstream <-chan In, fn func(In) Out) <-chan Out {
+	out := make(chan Out)
+	go func() {
+		defer close(out)
+		for v := range OrDone(done, upstream) {
+			select {
+			case out <- fn(v):
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// SupervisedMap is Map with the panics of fn recovered by s, the value
+// fn panicked on is dropped and, unless s aborts, the next one mapped.
+// Map holding no state of its own, a restart is no different from a
+// skip but for counting against the supervisor's restarts.
+func SupervisedMap[In, Out any](done <-chan struct{}, upstream <-chan In, fn func(In) Out, s *Supervisor) <-chan Out {
+	if s == nil {
+		return Map(done, upstream, fn)
+	}
+	out := make(chan Out)
+	go func() {
+		defer close(out)
+		for v := range OrDone(done, upstream) {
+			mapped, err := Protect("map", v, func(v In) (Out, error) {
+				return fn(v), nil
+			})
+			if err != nil {
+				if s.recovered(err) == AbortOnPanic {
+					return
+				}
+				continue
+			}
+			select {
+			case out <- mapped:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Filter forwards only the upstream values keep returns true for.
+func Filter[T any](done <-chan struct{}, upstream <-chan T, keep func(T) bool) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for v := range OrDone(done, upstream) {
+			if !keep(v) {
+				continue
+			}
+			select {
+			case out <- v:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// FlatMap sends every value of fn of each upstream value downstream,
+// in order.  fn returns a sequence so that it can yield as many values
+// as it likes without collecting them first, slices.Values adapts a
+// slice:
+//
+//	words := pipeline.FlatMap(done, lines, func(l string) iter.Seq[string] {
+//		return slices.Values(strings.Fields(l))
+//	})
+func FlatMap[In, Out any](done <-chan struct{}, upstream <-chan In, fn func(In) iter.Seq[Out]) <-chan Out {
+	out := make(chan Out)
+	go func() {
+		defer close(out)
+		for v := range OrDone(done, upstream) {
+			for o := range fn(v) {
+				select {
+				case out <- o:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// Reduce folds upstream into a single value, starting from initial,
+// and sends it downstream once upstream is exhausted.  Nothing is sent
+// if done is closed first, a partial aggregate is no answer.
+func Reduce[T, R any](done <-chan struct{}, upstream <-chan T, initial R, fn func(R, T) R) <-chan R {
+	out := make(chan R, 1)
+	go func() {
+		defer close(out)
+		acc := initial
+		for v := range OrDone(done, upstream) {
+			acc = fn(acc, v)
+		}
+		select {
+		case <-done:
+		default:
+			out <- acc
+		}
+	}()
+	return out
+}
+
+// Scan is Reduce sending the running aggregate downstream after every
+// upstream value rather than only the final one.
+func Scan[T, R any](done <-chan struct{}, upstream <-chan T, initial R, fn func(R, T) R) <-chan R {
+	acc := initial
+	return Map(done, upstream, func(v T) R {
+		acc = fn(acc, v)
+		return acc
+	})
+}
+
+// Distinct forwards each upstream value unless it is among the last
+// size distinct values seen, size < 1 being treated as 1.  Only those
+// are remembered, the least recently seen being forgotten to make room,
+// so memory stays bounded however long the stream, and a value that
+// recurs after size others may be sent again.
+func Distinct[T comparable](done <-chan struct{}, upstream <-chan T, size int) <-chan T {
+	size = max(size, 1)
+	// recent holds the values seen, most recently seen at the front,
+	// seen finds their elements.
+	recent := list.New()
+	seen := make(map[T]*list.Element, size)
+	return Filter(done, upstream, func(v T) bool {
+		if e, ok := seen[v]; ok {
+			recent.MoveToFront(e)
+			return false
+		}
+		if recent.Len() == size {
+			delete(seen, recent.Remove(recent.Back()).(T))
+		}
+		seen[v] = recent.PushFront(v)
+		return true
+	})
+}
+
+// Batch groups upstream values into slices of size, sending each as
+// soon as it is full.  The last batch, sent once upstream is exhausted,
+// may be shorter.  size < 1 is treated as 1.
+func Batch[T any](done <-chan struct{}, upstream <-chan T, size int) <-chan []T {
+	size = max(size, 1)
+	out := make(chan []T)
+	go func() {
+		defer close(out)
+		send := func(b []T) bool {
+			select {
+			case out <- b:
+				return true
+			case <-done:
+				return false
+			}
+		}
+
+		batch := make([]T, 0, size)
+		for v := range OrDone(done, upstream) {
+			batch = append(batch, v)
+			if len(batch) < size {
+				continue
+			}
+			if !send(batch) {
+				return
+			}
+			batch = make([]T, 0, size)
+		}
+		if len(batch) > 0 {
+			select {
+			case <-done:
+			default:
+				send(batch)
+			}
+		}
+	}()
+	return out
+}
+
+// BatchTimeout is Batch with a deadline, a batch is also sent once
+// maxLatency has passed since its first value arrived, however short
+// it is.  A slow upstream then still sees its values move on promptly,
+// while a fast one gets the full batches that save on channel sends.
+func BatchTimeout[T any](done <-chan struct{}, upstream <-chan T, size int, maxLatency time.Duration) <-chan []T {
+	size = max(size, 1)
+	out := make(chan []T)
+	go func() {
+		defer close(out)
+		timer := time.NewTimer(maxLatency)
+		timer.Stop()
+		defer timer.Stop()
+
+		batch := make([]T, 0, size)
+		flush := func() bool {
+			timer.Stop()
+			select {
+			case out <- batch:
+			case <-done:
+				return false
+			}
+			batch = make([]T, 0, size)
+			return true
+		}
+		for {
+			select {
+			case v, ok := <-upstream:
+				if !ok {
+					if len(batch) > 0 {
+						flush()
+					}
+					return
+				}
+				batch = append(batch, v)
+				if len(batch) == 1 {
+					timer.Reset(maxLatency)
+				}
+				if len(batch) == size && !flush() {
+					return
+				}
+			case <-timer.C:
+				if len(batch) > 0 && !flush() {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Unbatch undoes Batch, sending the values of each upstream slice on
+// one at a time.
+func Unbatch[T any](done <-chan struct{}, upstream <-chan []T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for batch := range OrDone(done, upstream) {
+			for _, v := range batch {
+				select {
+				case out <- v:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
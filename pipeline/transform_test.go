@@ -0,0 +1,393 @@
+package pipeline
+
+import (
+	"fmt"
+	"iter"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMap(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream []int
+		fn       func(int) int
+		want     []int
+	}{
+		{"empty", nil, func(v int) int { return v }, nil},
+		{"doubles", []int{1, 2, 3}, func(v int) int { return 2 * v }, []int{2, 4, 6}},
+		{"keeps order", []int{3, 1, 2}, func(v int) int { return -v }, []int{-3, -1, -2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			noLeaks(t)
+			done := make(chan struct{})
+			defer close(done)
+			if got := collect(t, Map(done, Generator(done, tt.upstream...), tt.fn)); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("cancelled", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		out := Map(done, Repeat(done, 1), func(v int) int { return v })
+		<-out
+		close(done)
+		closes(t, out)
+	})
+}
+
+func TestFilter(t *testing.T) {
+	even := func(v int) bool { return v%2 == 0 }
+	tests := []struct {
+		name     string
+		upstream []int
+		want     []int
+	}{
+		{"empty", nil, nil},
+		{"none kept", []int{1, 3}, nil},
+		{"some kept", []int{1, 2, 3, 4}, []int{2, 4}},
+		{"all kept", []int{2, 4}, []int{2, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			noLeaks(t)
+			done := make(chan struct{})
+			defer close(done)
+			if got := collect(t, Filter(done, Generator(done, tt.upstream...), even)); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("cancelled", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		out := Filter(done, Repeat(done, 1, 2), even)
+		<-out
+		close(done)
+		closes(t, out)
+	})
+}
+
+func TestBatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream []int
+		size     int
+		want     [][]int
+	}{
+		{"empty", nil, 2, nil},
+		{"exact", []int{1, 2, 3, 4}, 2, [][]int{{1, 2}, {3, 4}}},
+		{"short last", []int{1, 2, 3}, 2, [][]int{{1, 2}, {3}}},
+		{"larger than upstream", []int{1, 2}, 5, [][]int{{1, 2}}},
+		{"size below one", []int{1, 2}, 0, [][]int{{1}, {2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			noLeaks(t)
+			done := make(chan struct{})
+			defer close(done)
+			got := collect(t, Batch(done, Generator(done, tt.upstream...), tt.size))
+			if !slices.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("cancelled", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		out := Batch(done, Repeat(done, 1), 3)
+		<-out
+		close(done)
+		closes(t, out)
+	})
+
+	t.Run("cancelled with a partial batch", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		up := make(chan int)
+		out := Batch(done, up, 3)
+		up <- 1
+		close(done)
+		closes(t, out)
+	})
+}
+
+func TestBatchTimeout(t *testing.T) {
+	t.Run("full batches", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		defer close(done)
+		got := collect(t, BatchTimeout(done, Generator(done, 1, 2, 3, 4, 5), 2, time.Hour))
+		if want := [][]int{{1, 2}, {3, 4}, {5}}; !slices.EqualFunc(got, want, slices.Equal) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("flushed by the timer", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		defer close(done)
+		up := make(chan int)
+		out := BatchTimeout(done, up, 10, 20*time.Millisecond)
+		up <- 1
+		up <- 2
+		// upstream stays open, only the timer can send this batch.
+		select {
+		case got := <-out:
+			if want := []int{1, 2}; !slices.Equal(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		case <-time.After(wait):
+			t.Fatal("partial batch not flushed")
+		}
+		up <- 3
+		close(up)
+		if got := collect(t, out); !slices.EqualFunc(got, [][]int{{3}}, slices.Equal) {
+			t.Errorf("got %v after the flush, want [[3]]", got)
+		}
+	})
+
+	t.Run("no empty batches", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		defer close(done)
+		up := make(chan int)
+		out := BatchTimeout(done, up, 10, 5*time.Millisecond)
+		time.Sleep(30 * time.Millisecond)
+		close(up)
+		if got := collect(t, out); len(got) != 0 {
+			t.Errorf("got %v, want nothing", got)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		up := make(chan int)
+		out := BatchTimeout(done, up, 10, time.Hour)
+		up <- 1
+		close(done)
+		closes(t, out)
+	})
+}
+
+func TestUnbatch(t *testing.T) {
+	noLeaks(t)
+	done := make(chan struct{})
+	defer close(done)
+	got := collect(t, Unbatch(done, Generator(done, []int{1, 2}, nil, []int{3})))
+	if want := []int{1, 2, 3}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestFlatMap(t *testing.T) {
+	// copies yields v v times.
+	copies := func(v int) iter.Seq[int] {
+		return func(yield func(int) bool) {
+			for range v {
+				if !yield(v) {
+					return
+				}
+			}
+		}
+	}
+	tests := []struct {
+		name     string
+		upstream []int
+		want     []int
+	}{
+		{"empty", nil, nil},
+		{"nothing yielded", []int{0, 0}, nil},
+		{"expands in order", []int{1, 0, 3, 2}, []int{1, 3, 3, 3, 2, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			noLeaks(t)
+			done := make(chan struct{})
+			defer close(done)
+			if got := collect(t, FlatMap(done, Generator(done, tt.upstream...), copies)); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("cancelled stops the seq", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		stopped := make(chan struct{})
+		out := FlatMap(done, Generator(done, 1), func(int) iter.Seq[int] {
+			return func(yield func(int) bool) {
+				defer close(stopped)
+				naturals(yield)
+			}
+		})
+		<-out
+		close(done)
+		closes(t, out)
+		stops(t, stopped)
+	})
+}
+
+func TestReduce(t *testing.T) {
+	sum := func(acc, v int) int { return acc + v }
+	tests := []struct {
+		name     string
+		upstream []int
+		want     int
+	}{
+		{"empty", nil, 10},
+		{"one", []int{5}, 15},
+		{"many", []int{1, 2, 3, 4}, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			noLeaks(t)
+			done := make(chan struct{})
+			defer close(done)
+			if got := collect(t, Reduce(done, Generator(done, tt.upstream...), 10, sum)); !slices.Equal(got, []int{tt.want}) {
+				t.Errorf("got %v, want [%d]", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("other type", func(t *testing.T) {
+		done := make(chan struct{})
+		defer close(done)
+		got := collect(t, Reduce(done, Generator(done, "a", "b"), []string{}, func(acc []string, v string) []string {
+			return append(acc, v)
+		}))
+		if len(got) != 1 || !slices.Equal(got[0], []string{"a", "b"}) {
+			t.Errorf("got %v, want [[a b]]", got)
+		}
+	})
+
+	t.Run("cancelled sends nothing", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		out := Reduce(done, Repeat(done, 1), 0, sum)
+		time.Sleep(time.Millisecond)
+		close(done)
+		if got := collect(t, out); len(got) != 0 {
+			t.Errorf("got %v, want no partial aggregate", got)
+		}
+	})
+}
+
+func TestScan(t *testing.T) {
+	noLeaks(t)
+	done := make(chan struct{})
+	defer close(done)
+	sum := func(acc, v int) int { return acc + v }
+	if got := collect(t, Scan(done, Generator(done, 1, 2, 3, 4), 10, sum)); !slices.Equal(got, []int{11, 13, 16, 20}) {
+		t.Errorf("got %v, want [11 13 16 20]", got)
+	}
+	if got := collect(t, Scan(done, Generator[int](done), 10, sum)); len(got) != 0 {
+		t.Errorf("got %v from nothing, want nothing", got)
+	}
+
+	t.Run("cancelled", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		out := Scan(done, Repeat(done, 1), 0, sum)
+		<-out
+		close(done)
+		closes(t, out)
+	})
+}
+
+func TestDistinct(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream []int
+		size     int
+		want     []int
+	}{
+		{"empty", nil, 3, nil},
+		{"all distinct", []int{1, 2, 3}, 2, []int{1, 2, 3}},
+		{"repeats", []int{1, 1, 2, 1, 2, 3}, 5, []int{1, 2, 3}},
+		{"size one drops runs", []int{1, 1, 2, 2, 1}, 1, []int{1, 2, 1}},
+		{"size below one", []int{1, 1, 2}, 0, []int{1, 2}},
+		{"forgotten after size others", []int{1, 2, 3, 1}, 2, []int{1, 2, 3, 1}},
+		// seeing 1 again keeps it, it is 2 that makes room for 3.
+		{"least recently seen forgotten", []int{1, 2, 1, 3, 1, 2}, 2, []int{1, 2, 3, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			noLeaks(t)
+			done := make(chan struct{})
+			defer close(done)
+			if got := collect(t, Distinct(done, Generator(done, tt.upstream...), tt.size)); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("cancelled", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		out := Distinct(done, Repeat(done, 1, 2, 3), 2)
+		<-out
+		close(done)
+		closes(t, out)
+	})
+}
+
+// BenchmarkBatching moves values through three stages one at a time,
+// then in batches of growing size, each batch paying for one channel
+// send per stage instead of one per value.  Batching and unbatching
+// is paid once either side of the stages.
+func BenchmarkBatching(b *testing.B) {
+	const stages = 3
+	inc := func(v int) int { return v + 1 }
+	incAll := func(batch []int) []int {
+		for i := range batch {
+			batch[i] = inc(batch[i])
+		}
+		return batch
+	}
+	b.Run("per item", func(b *testing.B) {
+		done := make(chan struct{})
+		defer close(done)
+		out := Repeat(done, 1)
+		for range stages {
+			out = Map(done, out, inc)
+		}
+		b.ResetTimer()
+		for range b.N {
+			<-out
+		}
+	})
+	batchers := []struct {
+		name  string
+		batch func(done <-chan struct{}, upstream <-chan int, size int) <-chan []int
+	}{
+		{"Batch", Batch[int]},
+		{"BatchTimeout", func(done <-chan struct{}, upstream <-chan int, size int) <-chan []int {
+			return BatchTimeout(done, upstream, size, time.Millisecond)
+		}},
+	}
+	for _, bt := range batchers {
+		for _, size := range []int{16, 256} {
+			b.Run(fmt.Sprintf("%s/size=%d", bt.name, size), func(b *testing.B) {
+				done := make(chan struct{})
+				defer close(done)
+				batches := bt.batch(done, Repeat(done, 1), size)
+				for range stages {
+					batches = Map(done, batches, incAll)
+				}
+				out := Unbatch(done, batches)
+				b.ResetTimer()
+				for range b.N {
+					<-out
+				}
+			})
+		}
+	}
+}
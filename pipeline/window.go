@@ -0,0 +1,141 @@
+package pipeline
+
+import (
+	"slices"
+	"time"
+)
+
+// CountWindow sends reduce of every window of size consecutive values,
+// a new window starting every step values.  With step equal to size
+// the windows tumble, each value falling in exactly one of them.  With
+// a smaller step they slide, overlapping by size - step values, and
+// with a larger one the values between windows are skipped.
+//
+// The first window is sent once it is full.  Once upstream is exhausted
+// a last, shorter window is sent if it holds values no window before it
+// had.  reduce may keep the slice it is given.  size and step < 1 are
+// treated as 1.
+func CountWindow[T, R any](done <-chan struct{}, upstream <-chan T, size, step int, reduce func([]T) R) <-chan R {
+	size, step = max(size, 1), max(step, 1)
+	out := make(chan R)
+	go func() {
+		defer close(out)
+		send := func(w []T) bool {
+			select {
+			case out <- reduce(slices.Clone(w)):
+				return true
+			case <-done:
+				return false
+			}
+		}
+
+		window := make([]T, 0, size)
+		fresh, skip := 0, 0 // values since the last window, values to skip
+		for v := range OrDone(done, upstream) {
+			if skip > 0 {
+				skip--
+				continue
+			}
+			if len(window) == size {
+				window = append(window[:0], window[1:]...)
+			}
+			window = append(window, v)
+			fresh++
+			if len(window) < size || fresh < min(step, size) {
+				continue
+			}
+			if !send(window) {
+				return
+			}
+			fresh = 0
+			if step >= size {
+				window, skip = window[:0], step-size
+			}
+		}
+		if fresh > 0 {
+			select {
+			case <-done:
+			default:
+				send(window)
+			}
+		}
+	}()
+	return out
+}
+
+// timed is a value and when it arrived.
+type timed[T any] struct {
+	at time.Time
+	v  T
+}
+
+// TimeWindow sends reduce of the values that arrived in the last width
+// of time, every step.  With step equal to width the windows tumble,
+// each value falling in exactly one of them.  With a smaller step they
+// slide, a value being in every window that ends within width of its
+// arrival, and with a larger one values that arrive between windows
+// are dropped.
+//
+// Only windows with values in them are sent.  Once upstream is
+// exhausted the window is sent a last time, as it stands, if values
+// arrived since it was last sent.  reduce may keep the slice it is
+// given.  step must be positive.
+func TimeWindow[T, R any](done <-chan struct{}, upstream <-chan T, width, step time.Duration, reduce func([]T) R) <-chan R {
+	out := make(chan R)
+	go func() {
+		defer close(out)
+		var window []timed[T]
+		fresh := false // values arrived since the last window
+		send := func() bool {
+			values := make([]T, len(window))
+			for i, t := range window {
+				values[i] = t.v
+			}
+			fresh = false
+			select {
+			case out <- reduce(values):
+				return true
+			case <-done:
+				return false
+			}
+		}
+
+		ticker := time.NewTicker(step)
+		defer ticker.Stop()
+		for {
+			select {
+			case v, ok := <-upstream:
+				if !ok {
+					if fresh {
+						select {
+						case <-done:
+						default:
+							send()
+						}
+					}
+					return
+				}
+				window = append(window, timed[T]{time.Now(), v})
+				fresh = true
+			case now := <-ticker.C:
+				// tumbling windows are emptied as they are sent, the
+				// others need values older than width aged out.
+				if step != width {
+					cutoff := now.Add(-width)
+					window = slices.DeleteFunc(window, func(t timed[T]) bool {
+						return !t.at.After(cutoff)
+					})
+				}
+				if len(window) > 0 && !send() {
+					return
+				}
+				if step >= width {
+					window = window[:0]
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,141 @@
+package pipeline
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// same is a reduce keeping the window as it is.
+func same[T any](w []T) []T { return w }
+
+func TestCountWindow(t *testing.T) {
+	tests := []struct {
+		name       string
+		upstream   []int
+		size, step int
+		want       [][]int
+	}{
+		{"empty", nil, 2, 2, nil},
+		{"tumbling", []int{1, 2, 3, 4, 5, 6}, 2, 2, [][]int{{1, 2}, {3, 4}, {5, 6}}},
+		{"tumbling short last", []int{1, 2, 3, 4, 5}, 2, 2, [][]int{{1, 2}, {3, 4}, {5}}},
+		{"tumbling never full", []int{1, 2}, 5, 5, [][]int{{1, 2}}},
+		{"sliding", []int{1, 2, 3, 4, 5}, 3, 1, [][]int{{1, 2, 3}, {2, 3, 4}, {3, 4, 5}}},
+		{"sliding by two", []int{1, 2, 3, 4, 5, 6}, 3, 2, [][]int{{1, 2, 3}, {3, 4, 5}, {4, 5, 6}}},
+		{"hopping", []int{1, 2, 3, 4, 5, 6, 7}, 2, 3, [][]int{{1, 2}, {4, 5}, {7}}},
+		{"below one", []int{1, 2}, 0, 0, [][]int{{1}, {2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			noLeaks(t)
+			done := make(chan struct{})
+			defer close(done)
+			got := collect(t, CountWindow(done, Generator(done, tt.upstream...), tt.size, tt.step, same[int]))
+			if !slices.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("reduced", func(t *testing.T) {
+		done := make(chan struct{})
+		defer close(done)
+		sum := func(w []int) (s int) {
+			for _, v := range w {
+				s += v
+			}
+			return s
+		}
+		got := collect(t, CountWindow(done, Generator(done, 1, 2, 3, 4), 2, 1, sum))
+		if want := []int{3, 5, 7}; !slices.Equal(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		out := CountWindow(done, Repeat(done, 1), 3, 1, same[int])
+		<-out
+		close(done)
+		closes(t, out)
+	})
+}
+
+func TestTimeWindow(t *testing.T) {
+	t.Run("tumbling", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		defer close(done)
+		up := make(chan int)
+		out := TimeWindow(done, up, 50*time.Millisecond, 50*time.Millisecond, same[int])
+		go func() {
+			defer close(up)
+			up <- 1
+			up <- 2
+			time.Sleep(120 * time.Millisecond)
+			up <- 3
+		}()
+		got := collect(t, out)
+		if want := [][]int{{1, 2}, {3}}; !slices.EqualFunc(got, want, slices.Equal) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("sliding", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		defer close(done)
+		up := make(chan int)
+		out := TimeWindow(done, up, 100*time.Millisecond, 10*time.Millisecond, same[int])
+		go func() {
+			defer close(up)
+			up <- 1
+			time.Sleep(200 * time.Millisecond)
+			up <- 2
+			time.Sleep(50 * time.Millisecond)
+		}()
+		got := collect(t, out)
+		// 1 is in every window for 100ms, then ages out before 2 arrives.
+		var ones, twos int
+		for _, w := range got {
+			switch {
+			case slices.Equal(w, []int{1}):
+				ones++
+			case slices.Equal(w, []int{2}):
+				twos++
+			default:
+				t.Fatalf("window %v mixes values 200ms apart", w)
+			}
+		}
+		if ones < 2 || twos < 2 {
+			t.Errorf("got %v, want each value in several windows", got)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		noLeaks(t)
+		done := make(chan struct{})
+		out := TimeWindow(done, Repeat(done, 1), 10*time.Millisecond, 10*time.Millisecond, same[int])
+		<-out
+		close(done)
+		closes(t, out)
+	})
+}
+
+// BenchmarkCountWindow is the cost of a sliding window per value.
+func BenchmarkCountWindow(b *testing.B) {
+	done := make(chan struct{})
+	defer close(done)
+	sum := func(w []int) (s int) {
+		for _, v := range w {
+			s += v
+		}
+		return s
+	}
+	out := CountWindow(done, Repeat(done, 1), 16, 1, sum)
+	b.ResetTimer()
+	for range b.N {
+		<-out
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"sync"
 	"time"
+
+	"github.com/symonk/concurrency/pipeline"
 )
 
 // main demonstrates the request sequence pattern.
@@ -19,10 +21,12 @@ import (
 // chan shared between messages of each goroutine.
 // resulting in A, B, A, B and so on and so forth.
 func main() {
-	fanned := fanInMerge(respond(1), respond(2), respond(3))
+	done := make(chan struct{})
+	defer close(done)
+	fanned := pipeline.Merge(done, respond(1), respond(2), respond(3))
 
 	/*
-		Even tho we have 3 invocations of fanInMerge, it actually spawns 5
+		Even tho we have 3 invocations of respond, it actually spawns 5
 		working routines internally, so we have 15 goroutines total (3x5)
 		all being fanned in through the fanned channel.
 
@@ -70,27 +74,6 @@ func (r reply) String() string {
 	return fmt.Sprintf("id: %d, duration: %s, message: %s", r.id, r.duration, r.message)
 }
 
-// fanInMerge merges the multiple channels of work
-func fanInMerge(ch ...<-chan reply) <-chan reply {
-	out := make(chan reply)
-	var wg sync.WaitGroup
-	wg.Add(len(ch))
-	for _, channel := range ch {
-		go func(c <-chan reply) {
-			defer wg.Done()
-			for v := range channel {
-				out <- v
-			}
-		}(channel)
-	}
-
-	go func() {
-		wg.Wait()
-		close(out)
-	}()
-	return out
-}
-
 // respond simulates IO bound calls to a server and returns a number
 // of responses on the out channel.
 // Each goroutine spawned by this function will push a new instance
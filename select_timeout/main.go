@@ -3,7 +3,10 @@ package main
 import (
 	"context"
 	"fmt"
+	"iter"
 	"time"
+
+	"github.com/symonk/concurrency/pipeline"
 )
 
 // main demonstrates how to utilise the select timeout
@@ -19,10 +22,14 @@ import (
 //
 // We give a goroutine upto 2 seconds to get through all the generated
 // values.  Should it be too slow, we terminate it.
+//
+// The values come from an iter.Seq, turned into a channel that stops
+// when the context does, so the goroutine producing them is terminated
+// too rather than left sleeping and sending to nobody.
 func main() {
-	c := generator()
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(2*time.Second))
 	defer cancelFn()
+	c := pipeline.Chan(ctx.Done(), generator())
 	/*
 		Here we will demonstrate how a long running goroutine
 		can be caused to terminate.  The done channel here is
@@ -49,15 +56,14 @@ func main() {
 	<-done
 }
 
-// generator yields the integer values 1 through 5.
-func generator() <-chan int {
-	out := make(chan int)
-	go func() {
+// generator yields the integer values 0 through 4.
+func generator() iter.Seq[int] {
+	return func(yield func(int) bool) {
 		for i := range 5 {
-			out <- i
+			if !yield(i) {
+				return
+			}
 			time.Sleep(500 * time.Millisecond) // Exceed the context deadline
 		}
-		close(out)
-	}()
-	return out
+	}
 }